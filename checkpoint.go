@@ -0,0 +1,154 @@
+package conversion
+
+import (
+	"errors"
+	"os"
+)
+
+// Checkpoint ...
+type Checkpoint struct {
+	Episode    string            `json:"episode"`    //集数
+	Hash       map[string]string `json:"hash"`       //步骤:哈希地址
+	Output     string            `json:"output"`     //切片目录
+	Temp       string            `json:"temp"`       //切片临时目录,上传成功后清除
+	Renditions []*Rendition      `json:"renditions"` //切片清晰度
+	Subtitles  []*Subtitle       `json:"subtitles"`  //切片字幕
+	M3U8       string            `json:"m3u8"`       //M3U8名
+	Sharpness  string            `json:"sharpness"`  //清晰度
+	Key        string            `json:"key"`        //秘钥
+	Sample     []string          `json:"sample"`     //样板图
+	Caption    string            `json:"caption"`    //字幕
+	Finished   bool              `json:"finished"`   //已入库
+	Video      string            `json:"video"`      //入库视频ID
+}
+
+func newCheckpoint(episode string) *Checkpoint {
+	return &Checkpoint{
+		Episode: episode,
+		Hash:    make(map[string]string),
+	}
+}
+
+// Finished ...
+func (w *Work) Finished(episode, stage string) (string, bool) {
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+	cp, b := w.Checkpoints[episode]
+	if !b {
+		return "", false
+	}
+	s, b := cp.Hash[stage]
+	return s, b
+}
+
+func (w *Work) finishedCheckpoint(episode, stage string) (Checkpoint, bool) {
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+	cp, b := w.Checkpoints[episode]
+	if !b {
+		return Checkpoint{}, false
+	}
+	if _, b := cp.Hash[stage]; !b {
+		return Checkpoint{}, false
+	}
+	return *cp, true
+}
+
+func (w *Work) episodeFinished(episode string) bool {
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+	cp, b := w.Checkpoints[episode]
+	return b && cp.Finished
+}
+
+// finishedVideo load the video stored by the episode finished in the last run
+func (w *Work) finishedVideo(ep *Episode) (*Video, error) {
+	w.lock.RLock()
+	var id string
+	if cp, b := w.Checkpoints[ep.name]; b {
+		id = cp.Video
+	}
+	w.lock.RUnlock()
+	if id != "" {
+		return FindVideo(nil, id)
+	}
+	//the checkpoint was saved before the video id was recorded
+	v := new(Video)
+	b, e := _database.Where("no = ? AND season = ? AND episode = ?", ep.video.No, ep.video.Season, ep.video.Episode).Desc("created_at").Get(v)
+	if e != nil || !b {
+		return nil, errors.New("video not found")
+	}
+	return v, nil
+}
+
+// checkpoint save the stage result after the stage block was done
+func (w *Work) checkpoint(episode string, f func(cp *Checkpoint)) error {
+	w.lock.Lock()
+	if w.Checkpoints == nil {
+		w.Checkpoints = make(map[string]*Checkpoint)
+	}
+	cp, b := w.Checkpoints[episode]
+	if !b {
+		cp = newCheckpoint(episode)
+		w.Checkpoints[episode] = cp
+	}
+	f(cp)
+	w.lock.Unlock()
+	return Wrap(w.Update(), "checkpoint")
+}
+
+func (w *Work) stageCheckpoint(episode, stage, hash string) error {
+	return w.checkpoint(episode, func(cp *Checkpoint) {
+		cp.Hash[stage] = hash
+	})
+}
+
+// fragment returns the sliced output of the last run which was not uploaded
+func (w *Work) fragment(ep *Episode) (*Fragment, bool) {
+	w.lock.RLock()
+	cp, b := w.Checkpoints[ep.name]
+	if !b || cp.Temp == "" || len(cp.Renditions) == 0 {
+		w.lock.RUnlock()
+		return nil, false
+	}
+	f := &Fragment{
+		scale:      cp.Renditions[0].Scale,
+		temp:       cp.Temp,
+		output:     cp.Output,
+		skip:       w.Skip,
+		input:      ep.path,
+		sharpness:  cp.Sharpness,
+		m3u8:       cp.M3U8,
+		renditions: cp.Renditions,
+		subtitles:  cp.Subtitles,
+	}
+	w.lock.RUnlock()
+	if _, e := os.Stat(f.output); e != nil {
+		return nil, false
+	}
+	return f, true
+}
+
+// clearFragments remove the sliced output kept to resume the upload
+func (w *Work) clearFragments() {
+	var dirs []string
+	w.lock.RLock()
+	for _, cp := range w.Checkpoints {
+		if cp.Temp != "" {
+			dirs = append(dirs, cp.Temp)
+		}
+	}
+	w.lock.RUnlock()
+	for _, dir := range dirs {
+		w.clearTemp(dir)
+	}
+}
+
+// Clear ...
+func (w *Work) Clear() error {
+	w.clearFragments()
+	w.lock.Lock()
+	w.Checkpoints = nil
+	w.lock.Unlock()
+	return w.Reset()
+}
@@ -0,0 +1,131 @@
+package conversion
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/glvd/go-fftool"
+)
+
+// FindSourceHash ...
+func FindSourceHash(checksum string) (*Hash, error) {
+	return FindHash(_database.Where("hash_type = ?", HashTypeVideo), checksum)
+}
+
+// FindSliceHash find the slice rows of one uploaded dir that has the same checksum,crypto setting and renditions
+func FindSliceHash(checksum string, encrypt bool, key string, renditions []*Rendition) ([]*Hash, error) {
+	hashes, e := AllHash(_database.Where("checksum = ? AND hash_type = ? AND encrypt = ?", checksum, HashTypeSlice, encrypt).Asc("created_at"), 0)
+	if e != nil {
+		return nil, e
+	}
+	group := make(map[string][]*Hash)
+	var order []string
+	for _, h := range *hashes {
+		//key was the reserved word of sql,the slice encrypted by another key was not reused
+		if h.Key != key {
+			continue
+		}
+		if _, b := group[h.Hash]; !b {
+			order = append(order, h.Hash)
+		}
+		group[h.Hash] = append(group[h.Hash], h)
+	}
+	for _, hash := range order {
+		if hashes := uniqueHashes(group[hash]); matchRenditions(hashes, renditions) {
+			return hashes, nil
+		}
+	}
+	return nil, nil
+}
+
+// FindCaptionHash ...
+func FindCaptionHash(checksum string, hash string) ([]*Hash, error) {
+	hashes, e := AllHash(_database.Where("checksum = ? AND hash_type = ? AND hash = ?", checksum, HashTypeCaption, hash).Asc("created_at"), 0)
+	if e != nil {
+		return nil, e
+	}
+	return uniqueHashes(*hashes), nil
+}
+
+// uniqueHashes returns the first row of each file in the uploaded dir,the reused rows were copied for other episodes
+func uniqueHashes(hashes []*Hash) []*Hash {
+	var unique []*Hash
+	files := make(map[string]bool)
+	for _, h := range hashes {
+		file := h.Sharpness + "|" + h.Caption + "|" + filepath.ToSlash(h.M3U8)
+		if files[file] {
+			continue
+		}
+		files[file] = true
+		unique = append(unique, h)
+	}
+	return unique
+}
+
+func joinHashCaption(hashes []*Hash) string {
+	var ss []string
+	for _, h := range hashes {
+		if !ExistVerifyString(h.Caption, ss...) {
+			ss = append(ss, h.Caption)
+		}
+	}
+	return strings.Join(ss, ",")
+}
+
+func matchRenditions(hashes []*Hash, renditions []*Rendition) bool {
+	if len(hashes) != len(renditions) {
+		return false
+	}
+	for _, r := range renditions {
+		found := false
+		for _, h := range hashes {
+			if h.Sharpness == r.Sharpness && filepath.ToSlash(h.M3U8) == filepath.ToSlash(r.M3U8) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// expectRenditions returns the renditions the slice stage will output for the input
+func (w Work) expectRenditions(ep *Episode) ([]*Rendition, error) {
+	if !w.ladder() {
+		return []*Rendition{{
+			Scale:     w.WorkImpl.Scale,
+			Sharpness: Sharpness(w.WorkImpl.Scale),
+			M3U8:      fftool.DefaultM3U8Name,
+		}}, nil
+	}
+	format, e := ep.Probe()
+	if e != nil {
+		return nil, e
+	}
+	var height int64
+	if v := format.Video(); v != nil && v.Height != nil {
+		height = *v.Height
+	}
+	var renditions []*Rendition
+	for _, scale := range ladderScales(height, w.Scales...) {
+		renditions = append(renditions, &Rendition{
+			Scale:     scale,
+			Sharpness: Sharpness(scale),
+			M3U8:      filepath.Join(Sharpness(scale), fftool.DefaultM3U8Name),
+		})
+	}
+	return renditions, nil
+}
+
+// reuseHash insert a copy of the hash record for the episode
+func (ep *Episode) reuseHash(h *Hash) error {
+	n := h.Clone()
+	n.SetID("")
+	n.SetVersion(0)
+	n.Season = ep.video.Season
+	n.Episode = ep.video.Episode
+	n.Name = ep.video.No
+	return insertHash(n)
+}
@@ -0,0 +1,112 @@
+package conversion
+
+import (
+	"strconv"
+
+	"github.com/glvd/go-fftool"
+)
+
+// Episode ...
+type Episode struct {
+	work     *Work
+	index    int
+	name     string
+	path     string
+	video    *Video
+	checksum string
+	format   *fftool.StreamFormat
+}
+
+func newEpisode(work *Work, path string, total int, video *Video) *Episode {
+	en := ParseEpisode(path)
+	ep := &Episode{
+		work:  work,
+		index: en.Episode,
+		name:  en.Key(),
+		path:  path,
+		video: video,
+	}
+	ep.video.TotalEpisode = strconv.Itoa(total)
+	ep.video.Episode = strconv.Itoa(en.Episode)
+	if en.Season > 0 {
+		ep.video.Season = strconv.Itoa(en.Season)
+	}
+	return ep
+}
+
+// Work ...
+func (ep *Episode) Work() *Work {
+	return ep.work
+}
+
+// Index ...
+func (ep *Episode) Index() int {
+	return ep.index
+}
+
+// Name ...
+func (ep *Episode) Name() string {
+	return ep.name
+}
+
+// Path ...
+func (ep *Episode) Path() string {
+	return ep.path
+}
+
+// Video ...
+func (ep *Episode) Video() *Video {
+	return ep.video
+}
+
+// Finished returns the saved hash when the stage of episode was finished
+func (ep *Episode) Finished(stage string) (string, bool) {
+	return ep.work.Finished(ep.name, stage)
+}
+
+// Checkpoint ...
+func (ep *Episode) Checkpoint(stage, hash string) error {
+	return ep.work.stageCheckpoint(ep.name, stage, hash)
+}
+
+// Checksum ...
+func (ep *Episode) Checksum() string {
+	if ep.checksum == "" {
+		ep.checksum = Checksum(ep.path)
+	}
+	return ep.checksum
+}
+
+// Probe returns the cached stream format of the episode
+func (ep *Episode) Probe() (*fftool.StreamFormat, error) {
+	if ep.format == nil {
+		format, e := _ffprobe.StreamFormat(ep.path)
+		if e != nil {
+			return nil, Wrap(e, "probe error")
+		}
+		ep.format = format
+	}
+	return ep.format, nil
+}
+
+// Duration ...
+func (ep *Episode) Duration() float64 {
+	format, e := ep.Probe()
+	if e != nil {
+		return 0
+	}
+	d, _ := strconv.ParseFloat(format.Format.Duration, 64)
+	return d
+}
+
+// NewHash returns the hash record of the artifact uploaded for the episode
+func (ep *Episode) NewHash(tp HashType, checksum, hash string) *Hash {
+	return &Hash{
+		Checksum: checksum,
+		HashType: tp,
+		Season:   ep.video.Season,
+		Episode:  ep.video.Episode,
+		Name:     ep.video.No,
+		Hash:     hash,
+	}
+}
@@ -0,0 +1,120 @@
+package conversion
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrDuplicateEpisode ...
+var ErrDuplicateEpisode = errors.New("duplicate episode")
+
+// EpisodeName ...
+type EpisodeName struct {
+	Base    string //去掉集数后的文件名
+	Season  int    //季,0为未识别
+	Episode int    //集数
+}
+
+// EpisodeParser returns false if the name was not matched
+type EpisodeParser func(name string) (EpisodeName, bool)
+
+var _episodeParserLock = &sync.RWMutex{}
+var _episodeParsers = DefaultEpisodeParsers()
+
+// DefaultEpisodeParsers ...
+func DefaultEpisodeParsers() []EpisodeParser {
+	return []EpisodeParser{
+		RegexpEpisodeParser(`^(?P<base>.*)@(?P<letter>[A-Za-z])$`),
+		RegexpEpisodeParser(`(?i)^(?P<base>.*?)[ ._-]+S(?P<season>\d{1,3})[ ._-]?E(?P<episode>\d{1,4})(?:[ ._-].*)?$`),
+		RegexpEpisodeParser(`(?i)^(?P<base>.*?)[ ._-]+EP[ ._-]?(?P<episode>\d{1,4})$`),
+		RegexpEpisodeParser(`(?i)^(?P<base>.*?)[ ._-]+CD[ ._-]?(?P<episode>\d{1,2})$`),
+		RegexpEpisodeParser(`(?i)^(?P<base>.*?)[ ._-]+PART[ ._-]?(?P<episode>\d{1,2})$`),
+		RegexpEpisodeParser(`^(?P<base>.+?)_(?P<episode>\d{1,2})$`),
+	}
+}
+
+// RegexpEpisodeParser parse the name with the named groups base,season,episode or letter(A-Z as 1-26)
+func RegexpEpisodeParser(expr string) EpisodeParser {
+	reg := regexp.MustCompile(expr)
+	return func(name string) (EpisodeName, bool) {
+		match := reg.FindStringSubmatch(name)
+		if match == nil {
+			return EpisodeName{}, false
+		}
+		en := EpisodeName{Base: name}
+		for i, group := range reg.SubexpNames() {
+			switch group {
+			case "base":
+				en.Base = match[i]
+			case "season":
+				en.Season, _ = strconv.Atoi(match[i])
+			case "episode":
+				en.Episode, _ = strconv.Atoi(match[i])
+			case "letter":
+				en.Episode = ByteIndex(strings.ToUpper(match[i])[0]) + 1
+			}
+		}
+		if en.Episode <= 0 || en.Base == "" {
+			return EpisodeName{}, false
+		}
+		return en, true
+	}
+}
+
+// RegisterEpisodeParser the registered parser was tried before the defaults
+func RegisterEpisodeParser(parser EpisodeParser) {
+	_episodeParserLock.Lock()
+	defer _episodeParserLock.Unlock()
+	_episodeParsers = append([]EpisodeParser{parser}, _episodeParsers...)
+}
+
+// SetEpisodeParsers replace all the parsers
+func SetEpisodeParsers(parsers ...EpisodeParser) {
+	_episodeParserLock.Lock()
+	defer _episodeParserLock.Unlock()
+	_episodeParsers = parsers
+}
+
+// ParseEpisode returns the first episode 1 if no parser was matched
+func ParseEpisode(filename string) EpisodeName {
+	name := FileAbsName(filename)
+	_episodeParserLock.RLock()
+	defer _episodeParserLock.RUnlock()
+	for _, parser := range _episodeParsers {
+		if en, b := parser(name); b {
+			return en
+		}
+	}
+	return EpisodeName{
+		Base:    name,
+		Episode: 1,
+	}
+}
+
+// Key ...
+func (en EpisodeName) Key() string {
+	if en.Season > 0 {
+		return fmt.Sprintf("S%02dE%02d", en.Season, en.Episode)
+	}
+	return strconv.Itoa(en.Episode)
+}
+
+// checkEpisodes reject the paths which were resolved to the same episode
+func checkEpisodes(paths ...string) error {
+	exist := make(map[string]string)
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		key := ParseEpisode(path).Key()
+		if p, b := exist[key]; b {
+			return Permanent(Wrap(ErrDuplicateEpisode, fmt.Sprintf("%s(%s,%s)", key, p, path)))
+		}
+		exist[key] = path
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package conversion
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestParseEpisode ...
+func TestParseEpisode(t *testing.T) {
+	tests := []struct {
+		name string
+		want EpisodeName
+	}{
+		{name: "abc-123@B.mp4", want: EpisodeName{Base: "abc-123", Episode: 2}},
+		{name: "abc-123.mp4", want: EpisodeName{Base: "abc-123", Episode: 1}},
+		{name: "Show.S01E03.1080p.mkv", want: EpisodeName{Base: "Show", Season: 1, Episode: 3}},
+		{name: "show EP03.mp4", want: EpisodeName{Base: "show", Episode: 3}},
+		{name: "abc-123-CD2.avi", want: EpisodeName{Base: "abc-123", Episode: 2}},
+		{name: "abc part2.mp4", want: EpisodeName{Base: "abc", Episode: 2}},
+		{name: "abc_1.mp4", want: EpisodeName{Base: "abc", Episode: 1}},
+		//the single file ids were not episodes
+		{name: "DEEP-05.mp4", want: EpisodeName{Base: "DEEP-05", Episode: 1}},
+		{name: "SCD2.mp4", want: EpisodeName{Base: "SCD2", Episode: 1}},
+		{name: "ABC_123.mp4", want: EpisodeName{Base: "ABC_123", Episode: 1}},
+		{name: "BOSS01E02.mp4", want: EpisodeName{Base: "BOSS01E02", Episode: 1}},
+	}
+	for _, tt := range tests {
+		if got := ParseEpisode(tt.name); got != tt.want {
+			t.Errorf("ParseEpisode(%s) = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+	e := checkEpisodes("abc-CD1.mp4", "abc_1.mp4")
+	if !errors.Is(e, ErrDuplicateEpisode) || !IsPermanent(e) {
+		t.Fatal(e)
+	}
+}
@@ -0,0 +1,154 @@
+package conversion
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// EventWorkAdded ...
+const (
+	EventWorkAdded EventType = iota + 1
+	EventWorkStarted
+	EventStageCompleted
+	EventWorkFinished
+	EventWorkFailed
+	EventWorkStopped
+)
+
+// DefaultEventBuffer ...
+var DefaultEventBuffer = 64
+
+// EventType ...
+type EventType int
+
+// Event ...
+type Event struct {
+	Type    EventType `json:"type"`
+	ID      string    `json:"id"`      //任务ID
+	Episode string    `json:"episode"` //集数
+	Stage   string    `json:"stage"`   //完成的步骤
+	Hash    string    `json:"hash"`    //步骤结果CID
+	Videos  []*Video  `json:"videos"`  //完成的视频
+	Error   string    `json:"error"`   //失败原因
+	Time    time.Time `json:"time"`
+}
+
+type eventKey struct{}
+
+type subscriber struct {
+	ch    chan Event
+	types []EventType
+}
+
+type eventBus struct {
+	lock *sync.RWMutex
+	seq  uint64
+	subs map[uint64]*subscriber
+}
+
+// String ...
+func (t EventType) String() string {
+	switch t {
+	case EventWorkAdded:
+		return "added"
+	case EventWorkStarted:
+		return "started"
+	case EventStageCompleted:
+		return "stage_completed"
+	case EventWorkFinished:
+		return "finished"
+	case EventWorkFailed:
+		return "failed"
+	case EventWorkStopped:
+		return "stopped"
+	}
+	return "unknown"
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{
+		lock: &sync.RWMutex{},
+		subs: make(map[uint64]*subscriber),
+	}
+}
+
+// publish never blocked,the event was dropped if the subscriber was full
+func (b *eventBus) publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	for _, s := range b.subs {
+		if len(s.types) != 0 && !existEventType(e.Type, s.types...) {
+			continue
+		}
+		select {
+		case s.ch <- e:
+		default:
+			log.With("id", e.ID, "event", e.Type.String()).Warn("event dropped")
+		}
+	}
+}
+
+func (b *eventBus) subscribe(size int, types ...EventType) (<-chan Event, func()) {
+	if size <= 0 {
+		size = DefaultEventBuffer
+	}
+	s := &subscriber{
+		ch:    make(chan Event, size),
+		types: types,
+	}
+	b.lock.Lock()
+	b.seq++
+	id := b.seq
+	b.subs[id] = s
+	b.lock.Unlock()
+	once := &sync.Once{}
+	return s.ch, func() {
+		once.Do(func() {
+			b.lock.Lock()
+			delete(b.subs, id)
+			b.lock.Unlock()
+			close(s.ch)
+		})
+	}
+}
+
+func existEventType(t EventType, types ...EventType) bool {
+	for i := range types {
+		if types[i] == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Subscribe returns the channel of the events with types(all if empty),the events was dropped when the channel was full
+func (t *Task) Subscribe(size int, types ...EventType) (<-chan Event, func()) {
+	return t.events.subscribe(size, types...)
+}
+
+// OnEvent call the function with the events in a goroutine,returns the function to unsubscribe
+func (t *Task) OnEvent(f func(e Event), types ...EventType) func() {
+	ch, cancel := t.events.subscribe(DefaultEventBuffer, types...)
+	go func() {
+		for e := range ch {
+			f(e)
+		}
+	}()
+	return cancel
+}
+
+// withEvents ...
+func withEvents(ctx context.Context, b *eventBus) context.Context {
+	return context.WithValue(ctx, eventKey{}, b)
+}
+
+// emit publish the event to the task of context
+func emit(ctx context.Context, e Event) {
+	if b, ok := ctx.Value(eventKey{}).(*eventBus); ok {
+		b.publish(e)
+	}
+}
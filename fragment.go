@@ -1,17 +1,44 @@
 package conversion
 
-import "github.com/glvd/go-fftool"
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/glvd/go-fftool"
+)
+
+// DefaultMasterName ...
+var DefaultMasterName = "master.m3u8"
 
 // FragmentOption ...
 type FragmentOption func(f *Fragment)
 
 // Fragment ...
 type Fragment struct {
-	scale     Scale
-	output    string
-	skip      []string
-	input     string
-	sharpness string
+	scale      Scale
+	temp       string
+	output     string
+	skip       []string
+	input      string
+	sharpness  string
+	m3u8       string
+	renditions []*Rendition
+	subtitles  []*Subtitle
+}
+
+// Rendition ...
+type Rendition struct {
+	Scale       Scale  `json:"scale"`
+	Sharpness   string `json:"sharpness"`    //清晰度
+	M3U8        string `json:"m3u8"`         //相对切片目录的M3U8名
+	SegmentFile string `json:"segment_file"` //ts切片名
+	BitRate     int64  `json:"bit_rate"`
+	Width       int64  `json:"width"`
+	Height      int64  `json:"height"`
 }
 
 // Scale ...
@@ -29,11 +56,149 @@ func (s Fragment) Output() string {
 	return s.output
 }
 
+// Temp returns the temp dir which the output was under
+func (s Fragment) Temp() string {
+	return s.temp
+}
+
+// M3U8 ...
+func (s Fragment) M3U8() string {
+	return s.m3u8
+}
+
+// Renditions ...
+func (s Fragment) Renditions() []*Rendition {
+	return s.renditions
+}
+
+// Subtitles ...
+func (s Fragment) Subtitles() []*Subtitle {
+	return s.subtitles
+}
+
+// Sharpness ...
+func Sharpness(scale Scale) string {
+	return fmt.Sprintf("%dP", fftool.ScaleValue(scale))
+}
+
+// scaleBitRates the default bit rates of fftool
+var scaleBitRates = map[Scale]int64{
+	fftool.Scale480P:  500 * 1024,
+	fftool.Scale720P:  1000 * 1024,
+	fftool.Scale1080P: 2000 * 1024,
+}
+
+// sourceScale returns the max scale of source height as fftool limited
+func sourceScale(height int64) Scale {
+	switch {
+	case height <= 480:
+		return fftool.Scale480P
+	case height > 960:
+		return fftool.Scale1080P
+	}
+	return fftool.Scale720P
+}
+
+// scaleBitRate returns 0 if the source bit rate was lower as fftool did
+func scaleBitRate(scale Scale, source int64) int64 {
+	if b := scaleBitRates[scale]; source <= 0 || b <= source {
+		return b
+	}
+	return 0
+}
+
 func parseScale(scale int64) Scale {
-	if scale > 1080 {
+	if scale >= 1080 {
 		return fftool.Scale1080P
-	} else if scale > 720 {
+	} else if scale >= 720 {
 		return fftool.Scale720P
 	}
 	return fftool.Scale480P
 }
+
+// ladderScales returns the scales from high to low that not above the source height
+func ladderScales(height int64, scales ...Scale) []Scale {
+	max := parseScale(height)
+	if len(scales) == 0 {
+		for s := max; s >= fftool.Scale480P; s-- {
+			scales = append(scales, s)
+		}
+		return scales
+	}
+	var ladder []Scale
+	for s := fftool.Scale1080P; s >= fftool.Scale480P; s-- {
+		if s <= max && existScale(s, scales...) {
+			ladder = append(ladder, s)
+		}
+	}
+	if ladder == nil {
+		ladder = append(ladder, max)
+	}
+	return ladder
+}
+
+func existScale(scale Scale, scales ...Scale) bool {
+	for i := range scales {
+		if scales[i] == scale {
+			return true
+		}
+	}
+	return false
+}
+
+func newRendition(cfg fftool.Config, format *fftool.StreamFormat) *Rendition {
+	r := &Rendition{
+		Scale:       cfg.Scale,
+		Sharpness:   Sharpness(cfg.Scale),
+		M3U8:        cfg.M3U8Name,
+		SegmentFile: cfg.SegmentFileName,
+		BitRate:     cfg.BitRate,
+		Height:      fftool.ScaleValue(cfg.Scale),
+	}
+	video := format.Video()
+	if r.BitRate == 0 {
+		//the bit rate was not limited when the source was lower,estimate it not above the rendition
+		r.BitRate, _ = strconv.ParseInt(video.BitRate, 10, 64)
+		if b := scaleBitRates[r.Scale]; r.BitRate <= 0 || r.BitRate > b {
+			r.BitRate = b
+		}
+	}
+	if video.Width != nil && video.Height != nil && *video.Height > 0 {
+		//keep the aspect ratio and even width as scale=-2:height
+		r.Width = r.Height * *video.Width / *video.Height / 2 * 2
+	}
+	return r
+}
+
+// writeMaster write the master playlist with renditions and subtitles
+func writeMaster(path string, renditions []*Rendition, subtitles []*Subtitle) error {
+	buf := bytes.NewBufferString("#EXTM3U\n#EXT-X-VERSION:3\n")
+	group := ""
+	for _, sub := range subtitles {
+		//subtitles are selected by the player,none of them is shown by default
+		buf.WriteString(fmt.Sprintf("#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID=\"%s\",NAME=\"%s\",LANGUAGE=\"%s\",DEFAULT=NO,AUTOSELECT=YES,URI=\"%s\"\n",
+			subtitleGroup, sub.Name, sub.Language, filepath.ToSlash(sub.M3U8)))
+		group = fmt.Sprintf(",SUBTITLES=\"%s\"", subtitleGroup)
+	}
+	for _, r := range renditions {
+		buf.WriteString(fmt.Sprintf("#EXT-X-STREAM-INF:BANDWIDTH=%d,RESOLUTION=%dx%d%s\n", r.BitRate, r.Width, r.Height, group))
+		buf.WriteString(filepath.ToSlash(r.M3U8) + "\n")
+	}
+	return ioutil.WriteFile(path, buf.Bytes(), 0644)
+}
+
+func joinHashSharpness(hashes []*Hash) string {
+	var ss []string
+	for _, h := range hashes {
+		ss = append(ss, h.Sharpness)
+	}
+	return strings.Join(ss, ",")
+}
+
+func joinSharpness(renditions []*Rendition) string {
+	var ss []string
+	for _, r := range renditions {
+		ss = append(ss, r.Sharpness)
+	}
+	return strings.Join(ss, ",")
+}
@@ -0,0 +1,23 @@
+package conversion
+
+import (
+	"testing"
+
+	"github.com/glvd/go-fftool"
+)
+
+// TestLadderScales ...
+func TestLadderScales(t *testing.T) {
+	scales := ladderScales(1080)
+	if len(scales) != 3 || scales[0] != fftool.Scale1080P {
+		t.Fatal(scales)
+	}
+	scales = ladderScales(720, fftool.Scale480P, fftool.Scale1080P)
+	if len(scales) != 1 || scales[0] != fftool.Scale480P {
+		t.Fatal(scales)
+	}
+	scales = ladderScales(360, fftool.Scale1080P)
+	if len(scales) != 1 || scales[0] != fftool.Scale480P {
+		t.Fatal(scales)
+	}
+}
@@ -0,0 +1,125 @@
+package conversion
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+
+	"github.com/glvd/go-fftool"
+)
+
+// FrameSize ...
+type FrameSize struct {
+	Width  int64 `json:"width"`
+	Height int64 `json:"height"`
+}
+
+// FrameConfig ...
+type FrameConfig struct {
+	Poster      FrameSize `json:"poster"`       //海报尺寸
+	Thumb       FrameSize `json:"thumb"`        //缩略图尺寸
+	Sample      FrameSize `json:"sample"`       //样板图尺寸
+	PosterTime  string    `json:"poster_time"`  //海报截取时间,空值取时长1/3处
+	ThumbTime   string    `json:"thumb_time"`   //缩略图截取时间,空值取时长1/3处
+	SampleTimes []string  `json:"sample_times"` //样板图截取时间,空值按数量平均截取
+	SampleCount int       `json:"sample_count"` //样板图数量
+}
+
+// DefaultFrameConfig ...
+func DefaultFrameConfig() *FrameConfig {
+	return &FrameConfig{
+		Poster:      FrameSize{Width: 800, Height: 538},
+		Thumb:       FrameSize{Width: 380, Height: 538},
+		Sample:      FrameSize{Width: 800, Height: 450},
+		SampleCount: 0,
+	}
+}
+
+// FrameOption set nil to disable the frame extraction
+func FrameOption(cfg *FrameConfig) WorkOptions {
+	return func(impl *WorkImpl) {
+		impl.Frame = cfg
+	}
+}
+
+func (cfg *FrameConfig) sample() bool {
+	return cfg != nil && (cfg.SampleCount > 0 || len(cfg.SampleTimes) > 0)
+}
+
+// frameTimes returns the timestamps to extract,the empty one was evenly spaced by the duration
+func frameTimes(duration float64, count int, times ...string) []string {
+	if len(times) > 0 {
+		return times
+	}
+	var ts []string
+	for i := 0; i < count; i++ {
+		ts = append(ts, strconv.FormatFloat(duration*float64(i+1)/float64(count+1), 'f', 3, 64))
+	}
+	return ts
+}
+
+func frameTime(duration float64, t string) string {
+	if t != "" {
+		return t
+	}
+	return strconv.FormatFloat(duration/3, 'f', 3, 64)
+}
+
+// extractFrames extract frames of episode to a temp dir with scaled and cropped to size
+func (w Work) extractFrames(ctx context.Context, ep *Episode, name string, size FrameSize, times ...string) (string, []string, error) {
+	if len(times) == 0 {
+		return "", nil, errors.New("no frame time")
+	}
+	release, e := acquire(ctx, ResourceCPU)
+	if e != nil {
+		return "", nil, e
+	}
+	defer release()
+	dir, e := ioutil.TempDir(w.Output(), w.ID()+"_"+name+"_")
+	if e != nil {
+		return "", nil, Wrap(e, "frame dir")
+	}
+	var paths []string
+	for i, t := range times {
+		path := filepath.Join(dir, fmt.Sprintf("%s_%03d.jpg", name, i))
+		if e := extractFrame(ctx, ep.path, path, t, size); e != nil {
+			w.clearTemp(dir)
+			return "", nil, e
+		}
+		paths = append(paths, path)
+	}
+	return dir, paths, nil
+}
+
+func extractFrame(ctx context.Context, input, output, at string, size FrameSize) error {
+	vf := fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=increase,crop=%d:%d",
+		size.Width, size.Height, size.Width, size.Height)
+	cmd := exec.CommandContext(ctx, command("ffmpeg"), "-y", "-ss", at, "-i", input, "-frames:v", "1", "-vf", vf, output)
+	out, e := cmd.CombinedOutput()
+	if e != nil {
+		log.With("output", string(out), "args", cmd.Args).Error("extract frame")
+		return Wrap(e, "extract frame")
+	}
+	return nil
+}
+
+// command returns the command path like fftool
+func command(name string) string {
+	if _, e := exec.LookPath(name); e == nil {
+		return name
+	}
+	path := fftool.DefaultCommandPath
+	if !filepath.IsAbs(path) {
+		dir, e := filepath.Abs(filepath.Dir(os.Args[0]))
+		if e != nil {
+			return name
+		}
+		path = filepath.Join(dir, path)
+	}
+	return filepath.Join(path, name)
+}
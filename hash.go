@@ -24,6 +24,7 @@ type Hash struct {
 	Model       `xorm:"extends"`
 	Checksum    string   `xorm:"default() checksum" json:"checksum"`         //sum值
 	HashType    HashType `xorm:"default() hash_type" json:"hash_type"`       //类型
+	Season      string   `xorm:"default() season" json:"season"`             //季,同视频
 	Episode     string   `xorm:"default() episode" json:"episode"`           //总集数
 	Name        string   `xorm:"default() name" json:"name"`                 //banno
 	Hash        string   `xorm:"default() hash" json:"hash"`                 //哈希地址
@@ -73,6 +74,17 @@ func FindHash(session *xorm.Session, checksum string) (unfin *Hash, e error) {
 	return unfin, nil
 }
 
+func insertHash(h *Hash) error {
+	i, e := InsertOrUpdate(h)
+	if e != nil {
+		return e
+	}
+	if i == 0 {
+		log.With("name", h.Name, "type", h.HashType).Warn("hash not inserted")
+	}
+	return nil
+}
+
 // Clone ...
 func (h *Hash) Clone() (n *Hash) {
 	n = new(Hash)
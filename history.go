@@ -0,0 +1,71 @@
+package conversion
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// StageRecord ...
+type StageRecord struct {
+	Episode    string    `json:"episode"`     //集数
+	Stage      string    `json:"stage"`       //步骤
+	Attempt    int       `json:"attempt"`     //第几次执行
+	FinishedAt time.Time `json:"finished_at"` //完成时间
+}
+
+// History ...
+func (w *Work) History() []StageRecord {
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+	records := make([]StageRecord, len(w.StageHistory))
+	copy(records, w.StageHistory)
+	return records
+}
+
+// Videos returns the videos stored by the last run
+func (w *Work) Videos() []*Video {
+	return w.videos
+}
+
+// started record the start time of the run,
+// LastError,ErrorStage and ErrorEpisode are kept after a successful retry to tell why the work was retried
+func (w *Work) started() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.StartedAt = time.Now()
+	w.CompletedAt = time.Time{}
+	w.videos = nil
+}
+
+// stageFinished ...
+func (w *Work) stageFinished(episode, stage string) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.StageHistory = append(w.StageHistory, StageRecord{
+		Episode:    episode,
+		Stage:      stage,
+		Attempt:    w.WorkImpl.Attempt,
+		FinishedAt: time.Now(),
+	})
+}
+
+// failedAt record the error with the stage and episode where it was returned
+func (w *Work) failedAt(episode, stage string, e error) {
+	//stopped by user was not an error
+	if errors.Is(e, context.Canceled) || errors.Is(e, ErrDraining) {
+		return
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.LastError = e.Error()
+	w.ErrorStage = stage
+	w.ErrorEpisode = episode
+}
+
+// completed ...
+func (w *Work) completed() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.CompletedAt = time.Now()
+}
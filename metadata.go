@@ -0,0 +1,57 @@
+package conversion
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// metadata fill the technical metadata of video with the probed format
+func (ep *Episode) metadata() error {
+	format, e := ep.Probe()
+	if e != nil {
+		return e
+	}
+	video := ep.video
+	//the length supplied by the source was kept
+	if d := ep.Duration(); d > 0 && video.Length == "" {
+		video.Length = strconv.FormatInt(int64(math.Round(d)), 10)
+	}
+	video.BitRate, _ = strconv.ParseInt(format.Format.BitRate, 10, 64)
+	if v := format.Video(); v != nil {
+		video.Codec = v.CodecName
+		video.FrameRate = MustString(frameRate(v.AvgFrameRate), frameRate(v.RFrameRate))
+		if v.Width != nil && v.Height != nil {
+			video.Resolution = fmt.Sprintf("%dx%d", *v.Width, *v.Height)
+			if video.Sharpness == "" {
+				video.Sharpness = Sharpness(parseScale(*v.Height))
+			}
+		}
+	}
+	video.AudioLanguage = nil
+	for _, s := range format.Streams {
+		if s.CodecType == "audio" {
+			video.AudioCodec = MustString(video.AudioCodec, s.CodecName)
+			video.AudioLanguage = append(video.AudioLanguage, MustString(s.Tags.Language, "und"))
+		}
+	}
+	return nil
+}
+
+// frameRate format the rational frame rate like 24000/1001 to 23.976,returns empty if it was unknown as 0/0
+func frameRate(rate string) string {
+	fr := strings.Split(rate, "/")
+	if len(fr) != 2 {
+		return rate
+	}
+	n, e1 := strconv.ParseFloat(fr[0], 64)
+	d, e2 := strconv.ParseFloat(fr[1], 64)
+	if e1 != nil || e2 != nil {
+		return rate
+	}
+	if d == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(math.Round(n/d*1000)/1000, 'f', -1, 64)
+}
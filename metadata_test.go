@@ -0,0 +1,58 @@
+package conversion
+
+import (
+	"testing"
+
+	"github.com/glvd/go-fftool"
+)
+
+// TestFrameRate ...
+func TestFrameRate(t *testing.T) {
+	tests := map[string]string{
+		"24000/1001": "23.976",
+		"30/1":       "30",
+		"0/0":        "",
+		"25":         "25",
+	}
+	for rate, want := range tests {
+		if got := frameRate(rate); got != want {
+			t.Errorf("frameRate(%s) = %s, want %s", rate, got, want)
+		}
+	}
+}
+
+// TestMetadata ...
+func TestMetadata(t *testing.T) {
+	width, height := int64(1920), int64(1080)
+	ep := &Episode{
+		video: &Video{Length: "01:30:00"},
+		format: &fftool.StreamFormat{
+			Format: fftool.Format{Duration: "5400.4", BitRate: "4000000"},
+			Streams: []fftool.Stream{
+				{CodecType: "video", CodecName: "h264", AvgFrameRate: "0/0", RFrameRate: "24000/1001", Width: &width, Height: &height},
+				{CodecType: "audio", CodecName: "aac", Tags: fftool.StreamTags{Language: "jpn"}},
+				{CodecType: "audio", CodecName: "ac3"},
+			},
+		},
+	}
+	if e := ep.metadata(); e != nil {
+		t.Fatal(e)
+	}
+	v := ep.video
+	if v.Length != "01:30:00" {
+		t.Errorf("supplied length was overwritten:%s", v.Length)
+	}
+	if v.FrameRate != "23.976" || v.Resolution != "1920x1080" || v.Sharpness != "1080P" || v.BitRate != 4000000 {
+		t.Errorf("wrong video metadata:%s,%s,%s,%d", v.FrameRate, v.Resolution, v.Sharpness, v.BitRate)
+	}
+	if v.AudioCodec != "aac" || len(v.AudioLanguage) != 2 || v.AudioLanguage[1] != "und" {
+		t.Errorf("wrong audio metadata:%s,%v", v.AudioCodec, v.AudioLanguage)
+	}
+	ep.video = &Video{}
+	if e := ep.metadata(); e != nil {
+		t.Fatal(e)
+	}
+	if ep.video.Length != "5400" {
+		t.Errorf("probed length = %s", ep.video.Length)
+	}
+}
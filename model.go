@@ -285,7 +285,7 @@ func registerTable(m ISync) {
 	if _databaseTable == nil {
 		_databaseTable = make(map[string]ISync)
 	}
-	_databaseTable[reflect.TypeOf(m).Name()] = m
+	_databaseTable[reflect.Indirect(reflect.ValueOf(m)).Type().Name()] = m
 }
 
 // SyncTable ...
@@ -31,3 +31,18 @@ func TestFindAll(t *testing.T) {
 		t.Fatal(e)
 	}
 }
+
+// TestSyncTable ...
+func TestSyncTable(t *testing.T) {
+	if e := SyncTable(); e != nil {
+		t.Fatal(e)
+	}
+	for _, m := range []interface{}{&Video{}, &Hash{}} {
+		if b, e := _database.IsTableExist(m); e != nil || !b {
+			t.Fatalf("table of %T was not synced:%v", m, e)
+		}
+	}
+	if e := insertHash(&Hash{Checksum: "sync", HashType: HashTypeOther, Hash: "QmSync"}); e != nil {
+		t.Fatal(e)
+	}
+}
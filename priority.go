@@ -0,0 +1,186 @@
+package conversion
+
+import (
+	"container/heap"
+	"sort"
+	"time"
+)
+
+// PriorityOption the bigger priority work was run first
+func PriorityOption(priority int) WorkOptions {
+	return func(impl *WorkImpl) {
+		impl.Priority = priority
+	}
+}
+
+// Priority ...
+func (w Work) Priority() int {
+	return w.WorkImpl.Priority
+}
+
+type queueItem struct {
+	id       string
+	priority int
+	seq      uint64
+	index    int
+	schedule Schedule
+}
+
+// priorityQueue the higher priority was first and FIFO in the same priority
+type priorityQueue []*queueItem
+
+// Len ...
+func (pq priorityQueue) Len() int {
+	return len(pq)
+}
+
+// Less ...
+func (pq priorityQueue) Less(i, j int) bool {
+	if pq[i].priority != pq[j].priority {
+		return pq[i].priority > pq[j].priority
+	}
+	return pq[i].seq < pq[j].seq
+}
+
+// Swap ...
+func (pq priorityQueue) Swap(i, j int) {
+	pq[i], pq[j] = pq[j], pq[i]
+	pq[i].index = i
+	pq[j].index = j
+}
+
+// Push ...
+func (pq *priorityQueue) Push(x interface{}) {
+	item := x.(*queueItem)
+	item.index = len(*pq)
+	*pq = append(*pq, item)
+}
+
+// Pop ...
+func (pq *priorityQueue) Pop() interface{} {
+	old := *pq
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	item.index = -1
+	*pq = old[:n-1]
+	return item
+}
+
+type queueRecord struct {
+	ID       string `json:"id"`
+	Priority int    `json:"priority"`
+	Seq      uint64 `json:"seq"`
+}
+
+// push add the id to pending or change the priority if it was pending,must be called with lock
+func (q *Queue) push(s string, priority int) {
+	item, b := q.items[s]
+	if !b {
+		item = &queueItem{
+			id:    s,
+			index: -1,
+		}
+		q.items[s] = item
+	}
+	if item.index >= 0 && item.priority == priority {
+		return
+	}
+	q.seq++
+	item.priority, item.seq = priority, q.seq
+	if item.index >= 0 {
+		heap.Fix(&q.pending, item.index)
+		return
+	}
+	heap.Push(&q.pending, item)
+	q.wake()
+}
+
+// wake the waiting workers,must be called with lock
+func (q *Queue) wake() {
+	close(q.notify)
+	q.notify = make(chan struct{})
+}
+
+// pop returns the eligible item with the highest priority,must be called with lock
+func (q *Queue) pop() (string, bool) {
+	now := time.Now()
+	var skipped []*queueItem
+	var next time.Time
+	defer func() {
+		//the skipped items were kept the order with the seq
+		for _, item := range skipped {
+			heap.Push(&q.pending, item)
+		}
+		if !next.IsZero() {
+			q.wakeAt(next)
+		}
+	}()
+	for q.pending.Len() > 0 {
+		item := heap.Pop(&q.pending).(*queueItem)
+		t, b := item.schedule.Eligible(now)
+		if b {
+			return item.id, true
+		}
+		if next.IsZero() || t.Before(next) {
+			next = t
+		}
+		skipped = append(skipped, item)
+	}
+	return "", false
+}
+
+// remove must be called with lock
+func (q *Queue) remove(s string) {
+	if item, b := q.items[s]; b {
+		if item.index >= 0 {
+			heap.Remove(&q.pending, item.index)
+		}
+		delete(q.items, s)
+	}
+}
+
+// records returns all the queued items in order,must be called with lock
+func (q *Queue) records() []queueRecord {
+	items := make(priorityQueue, 0, len(q.items))
+	for _, item := range q.items {
+		items = append(items, item)
+	}
+	sort.Slice(items, items.Less)
+	records := make([]queueRecord, 0, len(items))
+	for _, item := range items {
+		records = append(records, queueRecord{
+			ID:       item.id,
+			Priority: item.priority,
+			Seq:      item.seq,
+		})
+	}
+	return records
+}
+
+// SetPriority change the priority of a waiting work,returns false if it was not waiting
+func (q *Queue) SetPriority(s string, priority int) bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	item, b := q.items[s]
+	if !b || item.index < 0 {
+		return false
+	}
+	q.push(s, priority)
+	q.persist()
+	return true
+}
+
+// SetPriority ...
+func (t *Task) SetPriority(id string, priority int) error {
+	iwork, e := LoadWork(id)
+	if e != nil {
+		return Wrap(e, "set priority")
+	}
+	iwork.Work().WorkImpl.Priority = priority
+	if err := iwork.Update(); err != nil {
+		return Wrap(err, "set priority")
+	}
+	t.queue.SetPriority(id, priority)
+	return nil
+}
@@ -0,0 +1,124 @@
+package conversion
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ProgressInterval ...
+var ProgressInterval = time.Second
+
+// Progress the progress of the running work,
+// Percent was estimated by the written segments for fftool did not report the ffmpeg time,
+// Uploaded was counted when each file or dir was added to the node
+type Progress struct {
+	ID           string  `json:"id"`
+	Episode      int     `json:"episode"`       //当前集数
+	TotalEpisode int     `json:"total_episode"` //总集数
+	Stage        string  `json:"stage"`         //当前步骤
+	Percent      float64 `json:"percent"`       //切片进度(按已写ts估算)
+	Uploaded     int64   `json:"uploaded"`      //已上传完成的字节
+}
+
+type progress struct {
+	lock sync.RWMutex
+	val  Progress
+}
+
+func newProgress(id string) *progress {
+	return &progress{
+		val: Progress{
+			ID: id,
+		},
+	}
+}
+
+// Load ...
+func (p *progress) Load() Progress {
+	if p == nil {
+		return Progress{}
+	}
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return p.val
+}
+
+func (p *progress) update(f func(val *Progress)) {
+	if p == nil {
+		return
+	}
+	p.lock.Lock()
+	f(&p.val)
+	p.lock.Unlock()
+}
+
+func (p *progress) reset(total int) {
+	p.update(func(val *Progress) {
+		*val = Progress{
+			ID:           val.ID,
+			TotalEpisode: total,
+		}
+	})
+}
+
+func (p *progress) stage(episode int, stage string) {
+	p.update(func(val *Progress) {
+		val.Episode = episode
+		val.Stage = stage
+		val.Percent = 0
+	})
+}
+
+func (p *progress) percent(percent float64) {
+	p.update(func(val *Progress) {
+		val.Percent = percent
+	})
+}
+
+func (p *progress) uploaded(size int64) {
+	p.update(func(val *Progress) {
+		val.Uploaded += size
+	})
+}
+
+// watchSlice estimate the ffmpeg percent with the written segments and the probed duration
+func (p *progress) watchSlice(ctx context.Context, dir string, hlsTime int, total float64) {
+	if total <= 0 || hlsTime <= 0 {
+		return
+	}
+	ticker := time.NewTicker(ProgressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			count := 0
+			_ = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+				if err == nil && !info.IsDir() && filepath.Ext(path) == ".ts" {
+					count++
+				}
+				return nil
+			})
+			percent := float64(count*hlsTime) / total * 100
+			if percent > 99 {
+				percent = 99
+			}
+			p.percent(percent)
+		}
+	}
+}
+
+// FileSize ...
+func FileSize(path string) (size int64) {
+	_ = filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
@@ -0,0 +1,64 @@
+package conversion
+
+import (
+	"context"
+)
+
+// ResourceCPU ...
+const (
+	ResourceCPU     = "cpu"
+	ResourceNetwork = "network"
+)
+
+// DefaultCPULimit the ffmpeg processes running at the same time
+var DefaultCPULimit = DefaultLimit
+
+// DefaultNetworkLimit the node uploads running at the same time
+var DefaultNetworkLimit = DefaultLimit
+
+// resourcePool no limit if it was nil
+type resourcePool chan struct{}
+
+type resourceKey struct {
+	name string
+}
+
+func newResourcePool(limit int) resourcePool {
+	if limit <= 0 {
+		return nil
+	}
+	return make(resourcePool, limit)
+}
+
+func (p resourcePool) acquire(ctx context.Context) error {
+	if p == nil {
+		return nil
+	}
+	select {
+	case p <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p resourcePool) release() {
+	if p == nil {
+		return
+	}
+	<-p
+}
+
+// withResource ...
+func withResource(ctx context.Context, name string, pool resourcePool) context.Context {
+	return context.WithValue(ctx, resourceKey{name: name}, pool)
+}
+
+// acquire wait for the named pool of the task,the returned release must be called after used
+func acquire(ctx context.Context, name string) (func(), error) {
+	pool, _ := ctx.Value(resourceKey{name: name}).(resourcePool)
+	if err := pool.acquire(ctx); err != nil {
+		return nil, err
+	}
+	return pool.release, nil
+}
@@ -0,0 +1,94 @@
+package conversion
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// DefaultMaxAttempts ...
+var DefaultMaxAttempts = 3
+
+// DefaultRetryInterval ...
+var DefaultRetryInterval = 30 * time.Second
+
+// DefaultMaxRetryInterval ...
+var DefaultMaxRetryInterval = 30 * time.Minute
+
+// ErrNotMedia ...
+var ErrNotMedia = errors.New("file is not a video/audio")
+
+type permanentError struct {
+	err error
+}
+
+// Error ...
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap ...
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent mark the error should not be retried
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// IsPermanent ...
+func IsPermanent(err error) bool {
+	var pe *permanentError
+	if errors.As(err, &pe) {
+		return true
+	}
+	return errors.Is(err, ErrNotMedia) ||
+		errors.Is(err, ErrWorkID) ||
+		errors.Is(err, ErrWorkTypeNotFound) ||
+		errors.Is(err, ErrStageNotFound)
+}
+
+// backoff returns the exponential interval before the next attempt
+func (t *Task) backoff(attempt int) time.Duration {
+	d := t.RetryInterval
+	for i := 1; i < attempt && d < t.MaxRetryInterval; i++ {
+		d *= 2
+	}
+	if d > t.MaxRetryInterval {
+		d = t.MaxRetryInterval
+	}
+	return d
+}
+
+// failed retry the work after backoff or mark it failed,returns true if it was retried
+func (t *Task) failed(work IWork, e error) bool {
+	if errors.Is(e, context.Canceled) {
+		return false
+	}
+	if IsPermanent(e) || work.Attempt() >= t.MaxAttempts {
+		log.With("id", work.ID(), "attempt", work.Attempt(), "error", e).Error("work failed")
+		if err := work.Fail(e); err != nil {
+			log.With("id", work.ID(), "error", err).Error("fail")
+		}
+		t.notify(Event{Type: EventWorkFailed, ID: work.ID(), Videos: work.Work().Videos(), Error: e.Error()})
+		return false
+	}
+	delay := t.backoff(work.Attempt())
+	log.With("id", work.ID(), "attempt", work.Attempt(), "delay", delay).Warn("work retry")
+	//the work was held in queue until the backoff was passed,it was persisted with the schedule
+	work.Work().Schedule.NotBefore = time.Now().Add(delay)
+	if err := work.Reset(); err != nil {
+		log.With("id", work.ID(), "error", err).Error("retry reset")
+		return false
+	}
+	t.queue.Release(work.ID())
+	//stopped or deleted while running
+	if t.queue.Has(work.ID()) {
+		t.queue.AddWork(work)
+	}
+	return true
+}
@@ -0,0 +1,87 @@
+package conversion
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+)
+
+// HashTypeSample ...
+const HashTypeSample HashType = "sample"
+
+// SampleDirOption ...
+func SampleDirOption(b bool) WorkOptions {
+	return func(impl *WorkImpl) {
+		impl.SampleDir = b
+	}
+}
+
+// addSample upload the sample images one by one or as one directory
+func (w Work) addSample(ctx context.Context, ep *Episode, paths []string) ([]string, error) {
+	if !w.SampleDir {
+		var samples []string
+		for _, p := range paths {
+			s, e := w.AddFile(ctx, p)
+			if e != nil {
+				return nil, Wrap(e, "add sample")
+			}
+			if err := insertHash(ep.NewHash(HashTypeSample, Checksum(p), s)); err != nil {
+				return nil, Wrap(err, "insert sample hash")
+			}
+			samples = append(samples, s)
+		}
+		return samples, nil
+	}
+
+	dir, e := ioutil.TempDir(w.Output(), w.ID()+"_sample_")
+	if e != nil {
+		return nil, Wrap(e, "sample dir")
+	}
+	defer os.RemoveAll(dir)
+	var names []string
+	for i, p := range paths {
+		name := filepath.Base(p)
+		if ExistVerifyString(name, names...) {
+			name = strconv.Itoa(i) + "_" + name
+		}
+		if e := copyFile(p, filepath.Join(dir, name)); e != nil {
+			return nil, Wrap(e, "copy sample")
+		}
+		names = append(names, name)
+	}
+	s, e := w.AddDir(ctx, dir)
+	if e != nil {
+		return nil, Wrap(e, "add sample")
+	}
+	var samples []string
+	for i, name := range names {
+		h := ep.NewHash(HashTypeSample, Checksum(paths[i]), s)
+		h.Resource = name
+		if err := insertHash(h); err != nil {
+			return nil, Wrap(err, "insert sample hash")
+		}
+		samples = append(samples, path.Join(s, name))
+	}
+	return samples, nil
+}
+
+func copyFile(src, dst string) error {
+	in, e := os.Open(src)
+	if e != nil {
+		return e
+	}
+	defer in.Close()
+	out, e := os.Create(dst)
+	if e != nil {
+		return e
+	}
+	_, e = io.Copy(out, in)
+	if err := out.Close(); e == nil {
+		e = err
+	}
+	return e
+}
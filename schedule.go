@@ -0,0 +1,106 @@
+package conversion
+
+import (
+	"fmt"
+	"time"
+)
+
+// Window the daily time window,it was crossed the midnight if start was after end
+type Window struct {
+	Start time.Duration `json:"start"` //每天开始时间(距0点)
+	End   time.Duration `json:"end"`   //每天结束时间(距0点)
+}
+
+// Schedule ...
+type Schedule struct {
+	NotBefore time.Time `json:"not_before"` //最早开始时间
+	Window    *Window   `json:"window"`     //每天允许执行的时间段
+}
+
+// NewWindow parse the window with 15:04 format
+func NewWindow(start, end string) (*Window, error) {
+	s, e := time.Parse("15:04", start)
+	if e != nil {
+		return nil, Wrap(e, "window start")
+	}
+	en, e := time.Parse("15:04", end)
+	if e != nil {
+		return nil, Wrap(e, "window end")
+	}
+	return &Window{
+		Start: time.Duration(s.Hour())*time.Hour + time.Duration(s.Minute())*time.Minute,
+		End:   time.Duration(en.Hour())*time.Hour + time.Duration(en.Minute())*time.Minute,
+	}, nil
+}
+
+// String ...
+func (w Window) String() string {
+	return fmt.Sprintf("%02d:%02d-%02d:%02d", int(w.Start.Hours()), int(w.Start.Minutes())%60, int(w.End.Hours()), int(w.End.Minutes())%60)
+}
+
+// NotBeforeOption ...
+func NotBeforeOption(t time.Time) WorkOptions {
+	return func(impl *WorkImpl) {
+		impl.Schedule.NotBefore = t
+	}
+}
+
+// WindowOption ...
+func WindowOption(window *Window) WorkOptions {
+	return func(impl *WorkImpl) {
+		impl.Schedule.Window = window
+	}
+}
+
+// Eligible returns true if the work could be run at now,or the next time to check
+func (s Schedule) Eligible(now time.Time) (time.Time, bool) {
+	if now.Before(s.NotBefore) {
+		return s.NotBefore, false
+	}
+	if s.Window == nil || s.Window.Start == s.Window.End {
+		return now, true
+	}
+	y, m, d := now.Date()
+	midnight := time.Date(y, m, d, 0, 0, 0, 0, now.Location())
+	offset := now.Sub(midnight)
+	start, end := s.Window.Start, s.Window.End
+	if start < end && offset >= start && offset < end {
+		return now, true
+	}
+	if start > end && (offset >= start || offset < end) {
+		return now, true
+	}
+	next := midnight.Add(start)
+	if !next.After(now) {
+		next = time.Date(y, m, d+1, 0, 0, 0, 0, now.Location()).Add(start)
+	}
+	return next, false
+}
+
+// AddWork add the work with the priority and schedule of it
+func (q *Queue) AddWork(work IWork) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.push(work.ID(), work.Priority())
+	q.items[work.ID()].schedule = work.Work().Schedule
+	q.persist()
+}
+
+// Pending returns the number of the works waiting in queue,they were not eligible if Get returns false
+func (q *Queue) Pending() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.pending.Len()
+}
+
+// wakeAt wake the waiting workers when the scheduled work was eligible,must be called with lock
+func (q *Queue) wakeAt(t time.Time) {
+	if q.timer != nil {
+		q.timer.Stop()
+	}
+	q.timer = time.AfterFunc(time.Until(t), func() {
+		q.lock.Lock()
+		defer q.lock.Unlock()
+		q.wake()
+	})
+}
@@ -0,0 +1,66 @@
+package conversion
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrDraining ...
+var ErrDraining = errors.New("task is draining")
+
+type drainKey struct{}
+
+// withDrain ...
+func withDrain(ctx context.Context, drain <-chan struct{}) context.Context {
+	return context.WithValue(ctx, drainKey{}, drain)
+}
+
+// draining returns true if the task was stopping gracefully
+func draining(ctx context.Context) bool {
+	drain, b := ctx.Value(drainKey{}).(<-chan struct{})
+	if !b {
+		return false
+	}
+	select {
+	case <-drain:
+		return true
+	default:
+		return false
+	}
+}
+
+// Shutdown stop dispatching works and wait the running works stopped at the next stage,
+// the running works was canceled after timeout
+func (t *Task) Shutdown(timeout time.Duration) error {
+	t.drainOnce.Do(func() {
+		close(t.drain)
+	})
+	if !t.started.Load() {
+		return nil
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-t.done:
+		return nil
+	case <-timer.C:
+	}
+	log.With("timeout", timeout).Warn("shutdown timeout,cancel the running works")
+	t.Stop()
+	<-t.done
+	return Wrap(context.DeadlineExceeded, "shutdown")
+}
+
+// interrupted returns true if the work was interrupted by task stopping,it will be run after restore
+func (t *Task) interrupted(work IWork, e error) bool {
+	if !errors.Is(e, ErrDraining) && t.context.Err() == nil {
+		return false
+	}
+	log.With("id", work.ID(), "error", e).Warn("work was interrupted")
+	if err := work.Reset(); err != nil {
+		log.With("id", work.ID(), "error", err).Error("interrupted reset")
+	}
+	t.queue.Release(work.ID())
+	return true
+}
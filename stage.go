@@ -0,0 +1,405 @@
+package conversion
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// StageProbe ...
+const (
+	StageProbe  = "probe"
+	StageSource = "source"
+	StageSlice  = "slice"
+	StagePoster = "poster"
+	StageThumb  = "thumb"
+	StageSample = "sample"
+)
+
+// ErrStageNotFound ...
+var ErrStageNotFound = errors.New("stage not found")
+
+// Stage ...
+type Stage interface {
+	Name() string
+	Depends() []string
+	Execute(ctx context.Context, ep *Episode) error
+}
+
+// StageFunc ...
+type StageFunc func(ctx context.Context, ep *Episode) error
+
+type stage struct {
+	name    string
+	depends []string
+	execute StageFunc
+}
+
+// NewStage ...
+func NewStage(name string, execute StageFunc, depends ...string) Stage {
+	return &stage{
+		name:    name,
+		depends: depends,
+		execute: execute,
+	}
+}
+
+// Name ...
+func (s *stage) Name() string {
+	return s.name
+}
+
+// Depends ...
+func (s *stage) Depends() []string {
+	return s.depends
+}
+
+// Execute ...
+func (s *stage) Execute(ctx context.Context, ep *Episode) error {
+	return s.execute(ctx, ep)
+}
+
+// DefaultPipeline ...
+func DefaultPipeline() []Stage {
+	return []Stage{
+		NewStage(StageProbe, probeStage),
+		NewStage(StageSource, sourceStage),
+		NewStage(StageSlice, sliceStage, StageProbe),
+		NewStage(StagePoster, posterStage, StageProbe),
+		NewStage(StageThumb, thumbStage, StageProbe),
+		NewStage(StageSample, sampleStage, StageProbe),
+	}
+}
+
+// validatePipeline check the stage names was unique and the dependencies was ordered before
+func validatePipeline(stages []Stage) error {
+	var names []string
+	for _, s := range stages {
+		if s == nil || s.Name() == "" {
+			return errors.New("stage name must input")
+		}
+		if ExistVerifyString(s.Name(), names...) {
+			return fmt.Errorf("stage[%s] was registered", s.Name())
+		}
+		for _, dep := range s.Depends() {
+			if !ExistVerifyString(dep, names...) {
+				return fmt.Errorf("stage[%s] depends on [%s] which is not before it", s.Name(), dep)
+			}
+		}
+		names = append(names, s.Name())
+	}
+	return nil
+}
+
+// SetPipeline ...
+func SetPipeline(name string, stages ...Stage) error {
+	if err := validatePipeline(stages); err != nil {
+		return err
+	}
+	_workTypeLock.Lock()
+	defer _workTypeLock.Unlock()
+	wt, b := _workTypes[name]
+	if !b {
+		return Wrap(ErrWorkTypeNotFound, name)
+	}
+	wt.pipeline = stages
+	return nil
+}
+
+// InsertStage insert the stage after the named stage,append to the end if after is empty
+func InsertStage(name string, s Stage, after string) error {
+	_workTypeLock.Lock()
+	defer _workTypeLock.Unlock()
+	wt, b := _workTypes[name]
+	if !b {
+		return Wrap(ErrWorkTypeNotFound, name)
+	}
+	idx := len(wt.pipeline)
+	if after != "" {
+		idx = stageIndex(wt.pipeline, after)
+		if idx == -1 {
+			return Wrap(ErrStageNotFound, after)
+		}
+		idx++
+	}
+	stages := make([]Stage, 0, len(wt.pipeline)+1)
+	stages = append(stages, wt.pipeline[:idx]...)
+	stages = append(stages, s)
+	stages = append(stages, wt.pipeline[idx:]...)
+	if err := validatePipeline(stages); err != nil {
+		return err
+	}
+	wt.pipeline = stages
+	return nil
+}
+
+func stageIndex(stages []Stage, name string) int {
+	for i, s := range stages {
+		if s.Name() == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Pipeline ...
+func Pipeline(name string) ([]Stage, error) {
+	wt, e := getWorkType(name)
+	if e != nil {
+		return nil, e
+	}
+	_workTypeLock.RLock()
+	defer _workTypeLock.RUnlock()
+	stages := make([]Stage, len(wt.pipeline))
+	copy(stages, wt.pipeline)
+	return stages, nil
+}
+
+// ValidateSkip check the skip names was registered in the pipeline of work type
+func ValidateSkip(name string, skip ...string) error {
+	stages, e := Pipeline(name)
+	if e != nil {
+		return e
+	}
+	for _, s := range skip {
+		if stageIndex(stages, s) == -1 {
+			return Wrap(ErrStageNotFound, "skip "+s)
+		}
+	}
+	return nil
+}
+
+func (w Work) pipeline() ([]Stage, error) {
+	if err := ValidateSkip(w.WorkType, w.Skip...); err != nil {
+		return nil, err
+	}
+	return Pipeline(w.WorkType)
+}
+
+// probe runs first,the file was missing or ffprobe could not read it will not be fixed by retry
+func probeStage(ctx context.Context, ep *Episode) error {
+	if _, e := os.Stat(ep.path); e != nil {
+		if os.IsNotExist(e) {
+			return Permanent(Wrap(e, "run probe"))
+		}
+		return Wrap(e, "run probe")
+	}
+	if e := ep.metadata(); e != nil {
+		return Permanent(Wrap(e, "run probe"))
+	}
+	if format, _ := ep.Probe(); !IsMedia(format) {
+		return Wrap(ErrNotMedia, "run probe")
+	}
+	return nil
+}
+
+func sourceStage(ctx context.Context, ep *Episode) error {
+	w, video := ep.work, ep.video
+	if s, b := ep.Finished(StageSource); b {
+		video.SourceHash = s
+		return nil
+	}
+	if h, err := FindSourceHash(ep.Checksum()); err == nil {
+		log.With("id", w.ID(), "episode", ep.name, "hash", h.Hash).Info("source was uploaded")
+		video.SourceHash = h.Hash
+		if err := ep.reuseHash(h); err != nil {
+			return Wrap(err, "insert source hash")
+		}
+		return ep.Checkpoint(StageSource, h.Hash)
+	}
+	s, e := w.AddFile(ctx, ep.path)
+	if e != nil {
+		return Wrap(e, "add source")
+	}
+	video.SourceHash = s
+	h := ep.NewHash(HashTypeVideo, ep.Checksum(), s)
+	h.Resource = ep.path
+	if err := insertHash(h); err != nil {
+		return Wrap(err, "insert source hash")
+	}
+	return ep.Checkpoint(StageSource, s)
+}
+
+func sliceStage(ctx context.Context, ep *Episode) error {
+	w, video := ep.work, ep.video
+	if cp, b := w.finishedCheckpoint(ep.name, StageSlice); b {
+		video.M3U8Hash = cp.Hash[StageSlice]
+		video.M3U8 = cp.M3U8
+		video.Sharpness = cp.Sharpness
+		video.Key = cp.Key
+		video.Caption = MustString(cp.Caption, video.Caption)
+		return nil
+	}
+	if hashes, err := w.dedupSlice(ep); err != nil {
+		return Wrap(err, "find slice")
+	} else if hashes != nil {
+		log.With("id", w.ID(), "episode", ep.name, "hash", hashes[0].Hash).Info("slice was uploaded")
+		video.M3U8Hash = hashes[0].Hash
+		captions, err := FindCaptionHash(ep.Checksum(), hashes[0].Hash)
+		if err != nil {
+			return Wrap(err, "find caption")
+		}
+		video.M3U8 = w.m3u8(hashes, captions)
+		video.Sharpness = joinHashSharpness(hashes)
+		video.Key = hashes[0].Key
+		video.Caption = MustString(joinHashCaption(captions), video.Caption)
+		for _, h := range append(hashes, captions...) {
+			if err := ep.reuseHash(h); err != nil {
+				return Wrap(err, "insert slice hash")
+			}
+		}
+		return w.checkpoint(ep.name, func(cp *Checkpoint) {
+			cp.Hash[StageSlice] = video.M3U8Hash
+			cp.M3U8 = video.M3U8
+			cp.Sharpness = video.Sharpness
+			cp.Key = video.Key
+			cp.Caption = joinHashCaption(captions)
+		})
+	}
+	f, b := w.fragment(ep)
+	if b {
+		log.With("id", w.ID(), "episode", ep.name, "path", f.Output()).Info("slice was kept")
+	} else {
+		var e error
+		if f, e = w.slice(ctx, ep); e != nil {
+			return Wrap(e, "run slice")
+		}
+		//keep the output to resume the upload when it was failed
+		if err := w.checkpoint(ep.name, func(cp *Checkpoint) {
+			cp.Output = f.Output()
+			cp.Temp = f.Temp()
+			cp.M3U8 = f.M3U8()
+			cp.Sharpness = f.Sharpness()
+			cp.Renditions = f.Renditions()
+			cp.Subtitles = f.Subtitles()
+		}); err != nil {
+			w.clearTemp(f.Temp())
+			return err
+		}
+	}
+	s, e := w.AddDir(ctx, f.Output())
+	if e != nil {
+		return Wrap(e, "add slice")
+	}
+	w.clearTemp(f.Temp())
+	video.M3U8Hash = s
+	video.M3U8 = f.M3U8()
+	video.Sharpness = f.Sharpness()
+	video.Key = w.key()
+	for _, r := range f.Renditions() {
+		h := ep.NewHash(HashTypeSlice, ep.Checksum(), s)
+		h.Sharpness = r.Sharpness
+		h.M3U8 = r.M3U8
+		h.SegmentFile = r.SegmentFile
+		h.Encrypt = w.Crypto != nil
+		h.Key = w.key()
+		if err := insertHash(h); err != nil {
+			return Wrap(err, "insert slice hash")
+		}
+	}
+	for _, sub := range f.Subtitles() {
+		h := ep.NewHash(HashTypeCaption, ep.Checksum(), s)
+		h.Caption = sub.Language
+		h.M3U8 = sub.M3U8
+		h.SegmentFile = DefaultSubtitleSegmentName
+		if err := insertHash(h); err != nil {
+			return Wrap(err, "insert caption hash")
+		}
+	}
+	caption := joinLanguage(f.Subtitles())
+	video.Caption = MustString(caption, video.Caption)
+	return w.checkpoint(ep.name, func(cp *Checkpoint) {
+		cp.Hash[StageSlice] = s
+		cp.Temp = ""
+		cp.Key = video.Key
+		cp.Caption = caption
+	})
+}
+
+func posterStage(ctx context.Context, ep *Episode) error {
+	w, video := ep.work, ep.video
+	if w.PosterPath == "" && w.Frame == nil {
+		return nil
+	}
+	if s, b := ep.Finished(StagePoster); b {
+		video.PosterHash = s
+		return nil
+	}
+	poster := w.PosterPath
+	if poster == "" {
+		dir, paths, e := w.extractFrames(ctx, ep, StagePoster, w.Frame.Poster, frameTime(ep.Duration(), w.Frame.PosterTime))
+		if e != nil {
+			return Wrap(e, "extract poster")
+		}
+		defer w.clearTemp(dir)
+		poster = paths[0]
+	}
+	s, e := w.AddFile(ctx, poster)
+	if e != nil {
+		return Wrap(e, "add poster")
+	}
+	video.PosterHash = s
+	if err := insertHash(ep.NewHash(HashTypePoster, Checksum(poster), s)); err != nil {
+		return Wrap(err, "insert poster hash")
+	}
+	return ep.Checkpoint(StagePoster, s)
+}
+
+func thumbStage(ctx context.Context, ep *Episode) error {
+	w, video := ep.work, ep.video
+	if w.ThumbPath == "" && w.Frame == nil {
+		return nil
+	}
+	if s, b := ep.Finished(StageThumb); b {
+		video.ThumbHash = s
+		return nil
+	}
+	thumb := w.ThumbPath
+	if thumb == "" {
+		dir, paths, e := w.extractFrames(ctx, ep, StageThumb, w.Frame.Thumb, frameTime(ep.Duration(), w.Frame.ThumbTime))
+		if e != nil {
+			return Wrap(e, "extract thumb")
+		}
+		defer w.clearTemp(dir)
+		thumb = paths[0]
+	}
+	s, e := w.AddFile(ctx, thumb)
+	if e != nil {
+		return Wrap(e, "add thumb")
+	}
+	video.ThumbHash = s
+	if err := insertHash(ep.NewHash(HashTypeThumb, Checksum(thumb), s)); err != nil {
+		return Wrap(err, "insert thumb hash")
+	}
+	return ep.Checkpoint(StageThumb, s)
+}
+
+func sampleStage(ctx context.Context, ep *Episode) error {
+	w, video := ep.work, ep.video
+	if len(w.SamplePath) == 0 && !w.Frame.sample() {
+		return nil
+	}
+	if cp, b := w.finishedCheckpoint(ep.name, StageSample); b {
+		video.Sample = cp.Sample
+		return nil
+	}
+	paths := w.SamplePath
+	if len(paths) == 0 {
+		dir, frames, e := w.extractFrames(ctx, ep, StageSample, w.Frame.Sample, frameTimes(ep.Duration(), w.Frame.SampleCount, w.Frame.SampleTimes...)...)
+		if e != nil {
+			return Wrap(e, "extract sample")
+		}
+		defer w.clearTemp(dir)
+		paths = frames
+	}
+	samples, e := w.addSample(ctx, ep, paths)
+	if e != nil {
+		return e
+	}
+	video.Sample = samples
+	return w.checkpoint(ep.name, func(cp *Checkpoint) {
+		cp.Hash[StageSample] = ""
+		cp.Sample = samples
+	})
+}
@@ -0,0 +1,244 @@
+package conversion
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/glvd/go-fftool"
+)
+
+// DefaultSubtitlePath ...
+var DefaultSubtitlePath = "subtitle"
+
+// DefaultSubtitleName ...
+var DefaultSubtitleName = "index.m3u8"
+
+// DefaultSubtitleSegmentName ...
+var DefaultSubtitleSegmentName = "sub-%05d.vtt"
+
+// SubtitleTimestampMap the mpegts of ffmpeg output was delayed 1.4s as default
+var SubtitleTimestampMap = "MPEGTS:126000,LOCAL:00:00:00.000"
+
+// SubtitleExts ...
+var SubtitleExts = []string{".srt", ".ass", ".ssa", ".vtt"}
+
+// bitmap subtitles can not convert to webvtt
+var bitmapSubtitles = []string{"hdmv_pgs_subtitle", "dvd_subtitle", "dvb_subtitle", "xsub"}
+
+const subtitleGroup = "subs"
+
+// Subtitle ...
+type Subtitle struct {
+	Language string `json:"language"` //语言
+	Name     string `json:"name"`     //目录名
+	M3U8     string `json:"m3u8"`     //相对切片目录的M3U8名
+	source   string
+	stream   int64
+}
+
+type vttCue struct {
+	start float64
+	end   float64
+	text  string
+}
+
+// SubtitleOption ...
+func SubtitleOption(b bool) WorkOptions {
+	return func(impl *WorkImpl) {
+		impl.Subtitle = b
+	}
+}
+
+// findSubtitles returns the sidecar subtitles next to the video and the embedded text subtitle streams
+func findSubtitles(input string, format *fftool.StreamFormat) []*Subtitle {
+	var subs []*Subtitle
+	base := FileAbsName(input)
+	infos, e := ioutil.ReadDir(filepath.Dir(input))
+	if e != nil {
+		log.With("path", input).Error(e)
+	}
+	for _, info := range infos {
+		ext := strings.ToLower(filepath.Ext(info.Name()))
+		if info.IsDir() || !ExistVerifyString(ext, SubtitleExts...) {
+			continue
+		}
+		name := strings.TrimSuffix(info.Name(), filepath.Ext(info.Name()))
+		lang := "und"
+		if name != base {
+			if !strings.HasPrefix(name, base+".") {
+				continue
+			}
+			lang = name[len(base)+1:]
+		}
+		subs = append(subs, &Subtitle{
+			Language: lang,
+			source:   filepath.Join(filepath.Dir(input), info.Name()),
+			stream:   -1,
+		})
+	}
+	for _, s := range format.Streams {
+		if s.CodecType != "subtitle" || ExistVerifyString(s.CodecName, bitmapSubtitles...) {
+			continue
+		}
+		subs = append(subs, &Subtitle{
+			Language: MustString(s.Tags.Language, "und"),
+			source:   input,
+			stream:   s.Index,
+		})
+	}
+	var names []string
+	for i, sub := range subs {
+		sub.Name = sub.Language
+		if ExistVerifyString(sub.Name, names...) {
+			sub.Name = sub.Language + "_" + strconv.Itoa(i)
+		}
+		names = append(names, sub.Name)
+	}
+	return subs
+}
+
+// subtitle convert the subtitles to segmented webvtt under the output
+func (w Work) subtitle(ctx context.Context, ep *Episode, output string) ([]*Subtitle, error) {
+	if !w.Subtitle {
+		return nil, nil
+	}
+	format, e := ep.Probe()
+	if e != nil {
+		return nil, e
+	}
+	subs := findSubtitles(ep.path, format)
+	for _, sub := range subs {
+		dir := filepath.Join(output, DefaultSubtitlePath, sub.Name)
+		if e := os.MkdirAll(dir, 0755); e != nil {
+			return nil, Wrap(e, "subtitle dir")
+		}
+		vtt := filepath.Join(dir, sub.Name+".vtt")
+		if e := convertSubtitle(ctx, sub, vtt); e != nil {
+			return nil, e
+		}
+		data, e := ioutil.ReadFile(vtt)
+		if e != nil {
+			return nil, Wrap(e, "read subtitle")
+		}
+		if e := os.Remove(vtt); e != nil {
+			return nil, Wrap(e, "remove subtitle")
+		}
+		if e := segmentVTT(parseVTT(string(data)), dir, fftool.DefaultHLSTime, ep.Duration()); e != nil {
+			return nil, Wrap(e, "segment subtitle")
+		}
+		sub.M3U8 = filepath.Join(DefaultSubtitlePath, sub.Name, DefaultSubtitleName)
+	}
+	return subs, nil
+}
+
+func convertSubtitle(ctx context.Context, sub *Subtitle, output string) error {
+	args := []string{"-y", "-i", sub.source}
+	if sub.stream >= 0 {
+		args = append(args, "-map", fmt.Sprintf("0:%d", sub.stream))
+	}
+	args = append(args, "-c:s", "webvtt", output)
+	cmd := exec.CommandContext(ctx, command("ffmpeg"), args...)
+	out, e := cmd.CombinedOutput()
+	if e != nil {
+		log.With("output", string(out), "args", cmd.Args).Error("convert subtitle")
+		return Wrap(e, "convert subtitle")
+	}
+	return nil
+}
+
+func parseVTT(data string) []*vttCue {
+	var cues []*vttCue
+	data = strings.ReplaceAll(data, "\r\n", "\n")
+	for _, block := range strings.Split(data, "\n\n") {
+		lines := strings.Split(strings.Trim(block, "\n"), "\n")
+		for i, line := range lines {
+			if !strings.Contains(line, "-->") {
+				continue
+			}
+			fields := strings.Fields(line)
+			if len(fields) < 3 {
+				break
+			}
+			start, e1 := parseVTTTime(fields[0])
+			end, e2 := parseVTTTime(fields[2])
+			if e1 != nil || e2 != nil {
+				break
+			}
+			cues = append(cues, &vttCue{
+				start: start,
+				end:   end,
+				text:  strings.Join(lines[i:], "\n"),
+			})
+			break
+		}
+	}
+	return cues
+}
+
+func parseVTTTime(s string) (float64, error) {
+	var sec float64
+	parts := strings.Split(s, ":")
+	for _, p := range parts {
+		v, e := strconv.ParseFloat(p, 64)
+		if e != nil {
+			return 0, e
+		}
+		sec = sec*60 + v
+	}
+	return sec, nil
+}
+
+// segmentVTT write the cues to segments by hls time and the subtitle playlist
+func segmentVTT(cues []*vttCue, dir string, hlsTime int, duration float64) error {
+	if duration <= 0 {
+		for _, c := range cues {
+			duration = math.Max(duration, c.end)
+		}
+	}
+	count := int(math.Ceil(duration / float64(hlsTime)))
+	if count == 0 {
+		count = 1
+	}
+	list := bytes.NewBufferString("#EXTM3U\n#EXT-X-VERSION:3\n")
+	list.WriteString(fmt.Sprintf("#EXT-X-TARGETDURATION:%d\n", hlsTime))
+	list.WriteString("#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n")
+	for i := 0; i < count; i++ {
+		start := float64(i * hlsTime)
+		end := math.Min(start+float64(hlsTime), duration)
+		if i == count-1 {
+			end = math.Max(end, duration)
+		}
+		seg := bytes.NewBufferString("WEBVTT\nX-TIMESTAMP-MAP=" + SubtitleTimestampMap + "\n")
+		for _, c := range cues {
+			//the cue over the boundary was written to every segment it overlapped
+			if c.end > start && c.start < end {
+				seg.WriteString("\n" + c.text + "\n")
+			}
+		}
+		name := fmt.Sprintf(DefaultSubtitleSegmentName, i)
+		if e := ioutil.WriteFile(filepath.Join(dir, name), seg.Bytes(), 0644); e != nil {
+			return e
+		}
+		list.WriteString(fmt.Sprintf("#EXTINF:%.3f,\n%s\n", end-start, name))
+	}
+	list.WriteString("#EXT-X-ENDLIST\n")
+	return ioutil.WriteFile(filepath.Join(dir, DefaultSubtitleName), list.Bytes(), 0644)
+}
+
+func joinLanguage(subs []*Subtitle) string {
+	var ss []string
+	for _, sub := range subs {
+		if !ExistVerifyString(sub.Language, ss...) {
+			ss = append(ss, sub.Language)
+		}
+	}
+	return strings.Join(ss, ",")
+}
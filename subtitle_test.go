@@ -0,0 +1,39 @@
+package conversion
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestSegmentVTT ...
+func TestSegmentVTT(t *testing.T) {
+	cues := parseVTT("WEBVTT\r\n\r\n1\r\n00:00:01.000 --> 00:00:03.000\r\nfirst\r\n\r\n00:09.000 --> 00:00:12.500\r\nsecond\r\n")
+	if len(cues) != 2 || cues[1].start != 9 || cues[1].end != 12.5 {
+		t.Fatal(cues)
+	}
+	dir, e := ioutil.TempDir("", "vtt")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(dir)
+	if e := segmentVTT(cues, dir, 10, 25); e != nil {
+		t.Fatal(e)
+	}
+	list, e := ioutil.ReadFile(filepath.Join(dir, DefaultSubtitleName))
+	if e != nil {
+		t.Fatal(e)
+	}
+	if strings.Count(string(list), "#EXTINF") != 3 {
+		t.Fatal(string(list))
+	}
+	seg, e := ioutil.ReadFile(filepath.Join(dir, "sub-00001.vtt"))
+	if e != nil {
+		t.Fatal(e)
+	}
+	if !strings.Contains(string(seg), "second") || strings.Contains(string(seg), "first") {
+		t.Fatal(string(seg))
+	}
+}
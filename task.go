@@ -12,23 +12,52 @@ import (
 	"go.uber.org/atomic"
 )
 
+// DefaultQueueKey ...
+var DefaultQueueKey = "queue"
+
 // Queue ...
 type Queue struct {
 	cacher  cacher.Cacher
-	queuing *sync.Map
-	tasking *sync.Pool
+	lock    *sync.Mutex
+	items   map[string]*queueItem //等待和执行中的任务
+	pending priorityQueue         //等待中的任务
+	seq     uint64
+	notify  chan struct{} //有新任务时关闭
+	timer   *time.Timer   //定时任务唤醒
 	running *sync.Map
 }
 
 // Task ...
 type Task struct {
-	context   context.Context
-	cancel    context.CancelFunc
-	queue     *Queue
-	autoStop  *atomic.Bool
-	Limit     int
-	Interval  int
-	ClearTemp bool
+	context          context.Context
+	cancel           context.CancelFunc
+	ctx              context.Context //带资源池的执行上下文
+	lock             *sync.Mutex
+	wg               *sync.WaitGroup
+	hooks            *sync.WaitGroup //webhook投递
+	workers          int
+	busy             *atomic.Int32
+	resize           chan struct{}
+	events           *eventBus
+	queue            *Queue
+	autoStop         *atomic.Bool
+	started          *atomic.Bool
+	drain            chan struct{}
+	drainOnce        *sync.Once
+	done             chan struct{}
+	Limit            int
+	CPULimit         int
+	NetworkLimit     int
+	Interval         int
+	ClearTemp        bool
+	Preflight        bool
+	MaxAttempts      int
+	RetryInterval    time.Duration
+	MaxRetryInterval time.Duration
+	Webhooks         []string
+	WebhookSecret    string
+	WebhookAttempts  int
+	WebhookInterval  time.Duration
 }
 
 // AutoStop ...
@@ -48,45 +77,48 @@ var DefaultLimit = 3
 func NewQueue(c cacher.Cacher) *Queue {
 	return &Queue{
 		cacher:  c,
-		queuing: &sync.Map{},
-		tasking: &sync.Pool{},
+		lock:    &sync.Mutex{},
+		items:   make(map[string]*queueItem),
+		notify:  make(chan struct{}),
 		running: &sync.Map{},
 	}
 }
 
 // Add ...
-func (q *Queue) Add(s string) {
-	q.queuing.Store(s, nil)
-	if err := q.cache(); err != nil {
-		log.Error(err)
-	}
-	q.tasking.Put(s)
+func (q *Queue) Add(s string, priority int) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.push(s, priority)
+	q.persist()
 }
 
 // Delete ...
 func (q *Queue) Delete(s string) {
-	q.queuing.Delete(s)
-	if err := q.cache(); err != nil {
-		log.Error(err)
-	}
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.remove(s)
+	q.persist()
 }
 
-// Get ...
-func (q *Queue) Get() (v string, b bool) {
-	if t := q.tasking.Get(); t != nil {
-		if v, b = t.(string); b {
-			if !q.Has(v) {
-				return "", false
-			}
-		}
+// Get returns the waiting work with the highest priority,it was kept in queue until finished
+func (q *Queue) Get() (string, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.pop()
+}
 
-	}
-	return
+// Wait returns the channel which was closed when a work was added,get it before Get to not miss the work
+func (q *Queue) Wait() <-chan struct{} {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.notify
 }
 
 // Has ...
 func (q *Queue) Has(s string) bool {
-	_, ok := q.queuing.Load(s)
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	_, ok := q.items[s]
 	return ok
 }
 
@@ -110,6 +142,11 @@ func (q *Queue) Stop(id string) {
 	q.Delete(id)
 }
 
+// Release ...
+func (q *Queue) Release(s string) {
+	q.running.Delete(s)
+}
+
 // Finish ...
 func (q *Queue) Finish(s string) {
 	q.running.Delete(s)
@@ -122,31 +159,43 @@ func (q *Queue) IsRunning(s string) (b bool) {
 	return
 }
 
-// List ...
-func (q *Queue) List() []string {
-	var runs []string
-	q.queuing.Range(func(key, value interface{}) bool {
-		if v, b := key.(string); b {
-			runs = append(runs, v)
+// RunningList ...
+func (q *Queue) RunningList() []IWork {
+	var works []IWork
+	q.running.Range(func(key, value interface{}) bool {
+		if v, b := value.(IWork); b {
+			works = append(works, v)
 		}
 		return true
 	})
+	return works
+}
+
+// List ...
+func (q *Queue) List() []string {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	var runs []string
+	for _, r := range q.records() {
+		runs = append(runs, r.ID)
+	}
 	return runs
 }
 
 // Restore ...
 func (q *Queue) Restore() ([]string, error) {
-	bytes, e := q.cacher.Get("running")
+	records, e := q.load()
 	if e != nil {
 		return nil, e
 	}
-	var runs []string
-	e = json.Unmarshal(bytes, &runs)
-	if e != nil {
-		return nil, e
-	}
-	for _, run := range runs {
-		work, err := LoadWork(run)
+	var restored []string
+	for _, r := range records {
+		work, err := LoadWork(r.ID)
+		if errors.Is(err, ErrWorkTypeNotFound) {
+			log.With("id", r.ID, "error", err).Error("restore")
+			q.Delete(r.ID)
+			continue
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -154,17 +203,43 @@ func (q *Queue) Restore() ([]string, error) {
 		if err != nil {
 			return nil, err
 		}
-		q.Add(run)
+		q.AddWork(work)
+		restored = append(restored, r.ID)
 	}
-	return runs, nil
+	return restored, nil
 }
 
-func (q *Queue) cache() error {
-	bytes, e := json.Marshal(q.List())
+// load read the queue records in order,the old running list was supported
+func (q *Queue) load() ([]queueRecord, error) {
+	var records []queueRecord
+	bytes, e := q.cacher.Get(DefaultQueueKey)
+	if e == nil {
+		return records, json.Unmarshal(bytes, &records)
+	}
+	bytes, err := q.cacher.Get("running")
+	if err != nil {
+		return nil, e
+	}
+	var runs []string
+	if err := json.Unmarshal(bytes, &runs); err != nil {
+		return nil, err
+	}
+	for _, run := range runs {
+		records = append(records, queueRecord{ID: run})
+	}
+	return records, nil
+}
+
+// persist must be called with lock
+func (q *Queue) persist() {
+	bytes, e := json.Marshal(q.records())
 	if e != nil {
-		return Wrap(e)
+		log.Error(Wrap(e, "queue marshal"))
+		return
+	}
+	if e := q.cacher.Set(DefaultQueueKey, bytes); e != nil {
+		log.Error(Wrap(e, "queue persist"))
 	}
-	return Wrap(q.cacher.Set("running", bytes))
 }
 
 // AddWorker ...
@@ -173,11 +248,16 @@ func (t *Task) AddWorker(work IWork, force bool) error {
 	if t.queue.IsRunning(work.ID()) {
 		return nil
 	}
+	if t.Preflight {
+		if err := t.Validate(work); err != nil {
+			return err
+		}
+	}
 
 	iwork, e := LoadWork(work.ID())
 	if e == nil {
 		if force {
-			if err := iwork.Reset(); err != nil {
+			if err := iwork.Clear(); err != nil {
 				return Wrap(err, "add work force")
 			}
 		}
@@ -186,6 +266,8 @@ func (t *Task) AddWorker(work IWork, force bool) error {
 			return Wrap(err, "add work store")
 		}
 	}
+	//published before queued,an idle worker may start the work at once
+	t.events.publish(Event{Type: EventWorkAdded, ID: work.ID()})
 	return t.StartWork(work.ID())
 }
 
@@ -198,14 +280,8 @@ func (t *Task) Stop() {
 
 // restore ...
 func (t *Task) restore() error {
-	ss, e := t.queue.Restore()
-	if e != nil {
-		return Wrap(e)
-	}
-	for _, v := range ss {
-		t.queue.Add(v)
-	}
-	return nil
+	_, e := t.queue.Restore()
+	return Wrap(e)
 }
 
 // Start ...
@@ -217,80 +293,200 @@ func (t *Task) Start() error {
 		return errors.New("node service was not ready")
 	}
 
+	if !t.started.CAS(false, true) {
+		return errors.New("task was started")
+	}
+	defer close(t.done)
+
 	if err := t.restore(); err != nil {
 		//ignore restore:first error key not found
 		log.Warnw("if not your first run,this has some problems", "error", err)
 	}
+	SweepTemp()
+	ctx := withDrain(t.context, t.drain)
+	//the works move between the pools by stages
+	ctx = withResource(ctx, ResourceCPU, newResourcePool(t.CPULimit))
+	ctx = withResource(ctx, ResourceNetwork, newResourcePool(t.NetworkLimit))
+	ctx = withEvents(ctx, t.events)
+
+	t.lock.Lock()
+	t.ctx = ctx
+	t.spawn()
+	t.lock.Unlock()
 
-	wg := &sync.WaitGroup{}
-	for i := 0; i < t.Limit; i++ {
-		wg.Add(1)
-		log.Infow("task thread start", "idx", i)
-		go func(wg *sync.WaitGroup) {
-			defer wg.Done()
-		WorkEnd:
-			for {
-				select {
-				case <-t.context.Done():
-					log.With("error", t.context.Err()).Error("done")
-					return
-				default:
-				}
-				if v, b := t.queue.Get(); b {
-					work, e := LoadWork(v)
-					if e != nil {
-						log.With("id", v, "error", e).Error("load work")
-						continue
-					}
-
-					if t.queue.Running(work) {
-						log.With("id", work.ID()).Warn("work was running")
-						continue
-					}
-
-					switch work.Status() {
-					case WorkWaiting:
-						log.With("id", work.ID()).Info("work run")
-
-						e = work.Run(t.context)
-						if e != nil {
-							log.With("id", work.ID(), "error", e).Error("run")
-						}
-					case WorkStopped:
-						log.With("id", work.ID()).Info("work was stopped")
-						continue
-					case WorkRunning:
-						log.With("id", work.ID()).Warn("work was running")
-						continue
-					case WorkFinish:
-						log.With("id", work.ID()).Warn("work was finished")
-						continue
-					default:
-						log.With("id", work.ID()).Error("work status wrong")
-						e := work.Reset()
-						if e != nil {
-							log.With("id", work.ID(), "error", e).Error("fix status error")
-							return
-						}
-					}
-					log.With("id", work.ID()).Info("end run")
-					t.queue.Finish(work.ID())
-					continue
-				}
-				if t.AutoStop() {
-					break WorkEnd
-				}
-				//service queuing for new Work
-				time.Sleep(5 * time.Second)
+	log.Info("waiting for end")
+	t.wg.Wait()
+	//the deliveries were aborted if the task was stopped
+	t.hooks.Wait()
+	return nil
+}
+
+// spawn start workers until the limit,must be called with lock
+func (t *Task) spawn() {
+	for t.workers < t.Limit {
+		t.workers++
+		t.wg.Add(1)
+		log.Infow("task thread start", "idx", t.workers)
+		go t.worker()
+	}
+}
+
+// retire returns true if the worker should exit for the limit was shrunk
+func (t *Task) retire() bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.workers > t.Limit {
+		t.workers--
+		return true
+	}
+	return false
+}
+
+// resized returns the channel which was closed when the limit was changed
+func (t *Task) resized() <-chan struct{} {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.resize
+}
+
+func (t *Task) worker() {
+	retired := false
+	defer func() {
+		if !retired {
+			t.lock.Lock()
+			t.workers--
+			t.lock.Unlock()
+		}
+		t.wg.Done()
+	}()
+	for {
+		select {
+		case <-t.context.Done():
+			log.With("error", t.context.Err()).Error("done")
+			return
+		case <-t.drain:
+			log.Info("drained")
+			return
+		default:
+		}
+		if retired = t.retire(); retired {
+			log.Info("task thread retired")
+			return
+		}
+		resize := t.resized()
+		wait := t.queue.Wait()
+		if v, b := t.queue.Get(); b {
+			t.busy.Inc()
+			next := t.process(v)
+			t.busy.Dec()
+			if !next {
+				return
 			}
-		}(wg)
+			continue
+		}
+		//the scheduled works were held until eligible,the worker was woken by the timer
+		if t.AutoStop() && t.queue.Pending() == 0 {
+			return
+		}
+		//service queuing for new Work
+		select {
+		case <-t.context.Done():
+		case <-t.drain:
+		case <-resize:
+		case <-wait:
+		}
 	}
+}
 
-	log.Info("waiting for end")
-	wg.Wait()
+// process run the work,returns false if the worker should exit
+func (t *Task) process(v string) bool {
+	work, e := LoadWork(v)
+	if e != nil {
+		log.With("id", v, "error", e).Error("load work")
+		return true
+	}
+
+	if t.queue.Running(work) {
+		log.With("id", work.ID()).Warn("work was running")
+		return true
+	}
+
+	switch work.Status() {
+	case WorkWaiting:
+		log.With("id", work.ID()).Info("work run")
+		t.events.publish(Event{Type: EventWorkStarted, ID: work.ID()})
+		e = work.Run(withKeepTemp(t.ctx, !t.ClearTemp))
+		if e == nil {
+			t.notify(Event{Type: EventWorkFinished, ID: work.ID(), Videos: work.Work().Videos()})
+		} else {
+			if t.interrupted(work, e) {
+				return true
+			}
+			log.With("id", work.ID(), "error", e).Error("run")
+			if t.failed(work, e) {
+				return true
+			}
+		}
+	case WorkFailed:
+		log.With("id", work.ID()).Warn("work was failed")
+	case WorkStopped:
+		log.With("id", work.ID()).Info("work was stopped")
+		return true
+	case WorkRunning:
+		log.With("id", work.ID()).Warn("work was running")
+		return true
+	case WorkFinish:
+		log.With("id", work.ID()).Warn("work was finished")
+		return true
+	default:
+		log.With("id", work.ID()).Error("work status wrong")
+		e := work.Reset()
+		if e != nil {
+			log.With("id", work.ID(), "error", e).Error("fix status error")
+			return false
+		}
+	}
+	log.With("id", work.ID()).Info("end run")
+	t.queue.Finish(work.ID())
+	return true
+}
+
+// SetLimit change the worker count while running,the running works was finished before the worker exit
+func (t *Task) SetLimit(limit int) error {
+	if limit < 1 {
+		return fmt.Errorf("wrong limit:%d", limit)
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.Limit = limit
+	//the workers were all exited after start returned
+	if t.workers > 0 {
+		t.spawn()
+	}
+	close(t.resize)
+	t.resize = make(chan struct{})
 	return nil
 }
 
+// GetLimit ...
+func (t *Task) GetLimit() int {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.Limit
+}
+
+// Workers ...
+func (t *Task) Workers() int {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.workers
+}
+
+// Busy returns the count of workers which were running a work
+func (t *Task) Busy() int {
+	return int(t.busy.Load())
+}
+
 // GetWorkStatus ...
 func (t *Task) GetWorkStatus(id string) (WorkStatus, error) {
 	work, e := LoadWork(id)
@@ -309,18 +505,37 @@ func (t *Task) GetWork(id string) (IWork, error) {
 	return LoadWork(id)
 }
 
+// GetProgress ...
+func (t *Task) GetProgress(id string) (Progress, bool) {
+	if v, b := t.queue.running.Load(id); b {
+		if work, b := v.(IWork); b {
+			return work.Progress(), true
+		}
+	}
+	return Progress{}, false
+}
+
+// AllProgress ...
+func (t *Task) AllProgress() []Progress {
+	var ps []Progress
+	for _, work := range t.queue.RunningList() {
+		ps = append(ps, work.Progress())
+	}
+	return ps
+}
+
 // StartWork ...
 func (t *Task) StartWork(id string) error {
 	iwork, e := LoadWork(id)
 	if e != nil {
 		return Wrap(e)
 	}
-	if iwork.Status() == WorkStopped {
+	if iwork.Status() == WorkStopped || iwork.Status() == WorkFailed {
 		if err := iwork.Reset(); err != nil {
 			return Wrap(err)
 		}
 	}
-	t.queue.Add(iwork.ID())
+	t.queue.AddWork(iwork)
 	return nil
 }
 
@@ -335,6 +550,7 @@ func (t *Task) StopWork(id string) {
 		if e := iwork.Stop(); e != nil {
 			log.Error(e)
 		} else {
+			t.events.publish(Event{Type: EventWorkStopped, ID: id})
 			return
 		}
 	} else {
@@ -358,10 +574,28 @@ func (t *Task) AllRun() (works []IWork, e error) {
 func NewTask() *Task {
 	ctx, cancel := context.WithCancel(context.Background())
 	return &Task{
-		context:  ctx,
-		cancel:   cancel,
-		queue:    NewQueue(_cache),
-		autoStop: atomic.NewBool(true),
-		Limit:    DefaultLimit,
+		context:          ctx,
+		cancel:           cancel,
+		queue:            NewQueue(_cache),
+		autoStop:         atomic.NewBool(true),
+		lock:             &sync.Mutex{},
+		wg:               &sync.WaitGroup{},
+		hooks:            &sync.WaitGroup{},
+		busy:             atomic.NewInt32(0),
+		resize:           make(chan struct{}),
+		events:           newEventBus(),
+		started:          atomic.NewBool(false),
+		drain:            make(chan struct{}),
+		drainOnce:        &sync.Once{},
+		done:             make(chan struct{}),
+		Limit:            DefaultLimit,
+		CPULimit:         DefaultCPULimit,
+		NetworkLimit:     DefaultNetworkLimit,
+		ClearTemp:        true,
+		MaxAttempts:      DefaultMaxAttempts,
+		RetryInterval:    DefaultRetryInterval,
+		MaxRetryInterval: DefaultMaxRetryInterval,
+		WebhookAttempts:  DefaultWebhookAttempts,
+		WebhookInterval:  DefaultWebhookInterval,
 	}
 }
@@ -1,9 +1,18 @@
 package conversion
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"github.com/glvd/go-fftool"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -23,6 +32,36 @@ func init() {
 	InitFFTool()
 }
 
+// testQueueKey use the queue of its own in the test
+func testQueueKey(t *testing.T, key string) {
+	old := DefaultQueueKey
+	DefaultQueueKey = key
+	t.Cleanup(func() {
+		DefaultQueueKey = old
+	})
+}
+
+// testWorkType register a work type running the stages with a queue of its own,it was removed after the test
+func testWorkType(t *testing.T, stages ...Stage) string {
+	name := tool.GenerateRandomString(8)
+	testQueueKey(t, "queue_"+name)
+	if e := RegisterWorkType(name, decodeInfo, nil, func(video IVideo) (string, error) {
+		return video.(*VideoInfo).ID, nil
+	}); e != nil {
+		t.Fatal(e)
+	}
+	t.Cleanup(func() {
+		unregisterWorkType(name)
+	})
+	if len(stages) == 0 {
+		return name
+	}
+	if e := SetPipeline(name, stages...); e != nil {
+		t.Fatal(e)
+	}
+	return name
+}
+
 // TestTask_Start ...
 func TestTask_Start(t *testing.T) {
 	task := NewTask()
@@ -68,3 +107,485 @@ func TestTask_Start(t *testing.T) {
 func TestWrap(t *testing.T) {
 	t.Log(Wrap(errors.New("err")))
 }
+
+// TestQueue_Priority ...
+func TestQueue_Priority(t *testing.T) {
+	q := NewQueue(_cache)
+	q.Add("low", 0)
+	q.Add("high1", 5)
+	q.Add("mid", 1)
+	q.Add("high2", 5)
+	q.Add("deleted", 9)
+	q.Delete("deleted")
+	q.SetPriority("low", 3)
+	var ids []string
+	for v, b := q.Get(); b; v, b = q.Get() {
+		ids = append(ids, v)
+		q.Finish(v)
+	}
+	if strings.Join(ids, ",") != "high1,high2,low,mid" {
+		t.Errorf("got %v", ids)
+	}
+}
+
+// TestQueue_Restore ...
+func TestQueue_Restore(t *testing.T) {
+	testQueueKey(t, "queue_restore_test")
+	q := NewQueue(_cache)
+	for i, id := range []string{"restore1", "restore2", "restore3", "restore4"} {
+		w, e := NewWork("info", &VideoInfo{ID: id}, PriorityOption(i%2))
+		if e != nil {
+			t.Fatal(e)
+		}
+		if e := w.Store(); e != nil {
+			t.Fatal(e)
+		}
+		q.Add(id, w.Priority())
+	}
+	//running works was kept until finished
+	if v, b := q.Get(); !b || v != "restore2" {
+		t.Fatal(v)
+	}
+	restored, e := NewQueue(_cache).Restore()
+	if e != nil {
+		t.Fatal(e)
+	}
+	if strings.Join(restored, ",") != "restore2,restore4,restore1,restore3" {
+		t.Errorf("got %v", restored)
+	}
+
+	q = NewQueue(_cache)
+	if _, e := q.Restore(); e != nil {
+		t.Fatal(e)
+	}
+	got := make(chan string, 4)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v, b := q.Get(); b {
+				got <- v
+				q.Finish(v)
+			}
+		}()
+	}
+	wg.Wait()
+	close(got)
+	if len(got) != 4 || len(q.List()) != 0 {
+		t.Errorf("got %d works,%d left", len(got), len(q.List()))
+	}
+}
+
+// TestQueue_Wait ...
+func TestQueue_Wait(t *testing.T) {
+	q := NewQueue(_cache)
+	wait := q.Wait()
+	go q.Add("wait", 0)
+	select {
+	case <-wait:
+	case <-time.After(time.Second):
+		t.Fatal("worker was not woken")
+	}
+	if v, b := q.Get(); !b || v != "wait" {
+		t.Fatal(v)
+	}
+	q.Finish("wait")
+}
+
+// TestTask_Shutdown ...
+func TestTask_Shutdown(t *testing.T) {
+	running := make(chan struct{})
+	var next bool
+	name := testWorkType(t, NewStage("first", func(ctx context.Context, ep *Episode) error {
+		close(running)
+		time.Sleep(200 * time.Millisecond)
+		return nil
+	}), NewStage("next", func(ctx context.Context, ep *Episode) error {
+		next = true
+		return nil
+	}))
+	work, e := NewWork(name, &VideoInfo{ID: tool.GenerateRandomString(8)}, VideoPathOption([]string{"drain.mp4"}))
+	if e != nil {
+		t.Fatal(e)
+	}
+	task := NewTask()
+	task.SetAutoStop(false)
+	if e := task.AddWorker(work, true); e != nil {
+		t.Fatal(e)
+	}
+	go func() {
+		if e := task.Start(); e != nil {
+			t.Error(e)
+		}
+	}()
+	<-running
+	if e := task.Shutdown(5 * time.Second); e != nil {
+		t.Fatal(e)
+	}
+	status, e := task.GetWorkStatus(work.ID())
+	if e != nil {
+		t.Fatal(e)
+	}
+	if next || status != WorkWaiting || !task.queue.Has(work.ID()) {
+		t.Errorf("work was not drained at the stage boundary:%v,%v", next, status)
+	}
+}
+
+// TestTask_SetLimit ...
+func TestTask_SetLimit(t *testing.T) {
+	testQueueKey(t, "queue_limit_test")
+	task := NewTask()
+	task.SetAutoStop(false)
+	task.Limit = 1
+	go func() {
+		if e := task.Start(); e != nil {
+			t.Error(e)
+		}
+	}()
+	workers := func(n int) {
+		for i := 0; i < 100 && task.Workers() != n; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if task.Workers() != n {
+			t.Fatalf("workers = %d, want %d", task.Workers(), n)
+		}
+	}
+	workers(1)
+	if e := task.SetLimit(3); e != nil {
+		t.Fatal(e)
+	}
+	workers(3)
+	if e := task.SetLimit(1); e != nil {
+		t.Fatal(e)
+	}
+	workers(1)
+	if task.GetLimit() != 1 || task.Busy() != 0 {
+		t.Errorf("limit = %d, busy = %d", task.GetLimit(), task.Busy())
+	}
+	if e := task.Shutdown(time.Second); e != nil {
+		t.Fatal(e)
+	}
+	workers(0)
+}
+
+// TestQueue_Schedule ...
+func TestQueue_Schedule(t *testing.T) {
+	testQueueKey(t, "queue_schedule_"+tool.GenerateRandomString(8))
+	window, e := NewWindow("22:00", "06:00")
+	if e != nil {
+		t.Fatal(e)
+	}
+	day := time.Date(2020, 1, 1, 12, 0, 0, 0, time.Local)
+	if next, b := (Schedule{Window: window}).Eligible(day); b || !next.Equal(day.Add(10*time.Hour)) {
+		t.Errorf("noon was out of window:%v", next)
+	}
+	if _, b := (Schedule{Window: window}).Eligible(day.Add(15 * time.Hour)); !b {
+		t.Error("3 am was in window")
+	}
+
+	q := NewQueue(_cache)
+	later, e := NewWork("info", &VideoInfo{ID: tool.GenerateRandomString(8)}, PriorityOption(9), NotBeforeOption(time.Now().Add(300*time.Millisecond)))
+	if e != nil {
+		t.Fatal(e)
+	}
+	now, e := NewWork("info", &VideoInfo{ID: tool.GenerateRandomString(8)})
+	if e != nil {
+		t.Fatal(e)
+	}
+	for _, w := range []IWork{later, now} {
+		if e := w.Store(); e != nil {
+			t.Fatal(e)
+		}
+		q.AddWork(w)
+	}
+	if v, b := q.Get(); !b || v != now.ID() {
+		t.Fatal(v)
+	}
+	q.Finish(now.ID())
+	q = NewQueue(_cache)
+	if _, e := q.Restore(); e != nil {
+		t.Fatal(e)
+	}
+	wait := q.Wait()
+	if v, b := q.Get(); b {
+		t.Fatal("scheduled work was run early:" + v)
+	}
+	select {
+	case <-wait:
+	case <-time.After(time.Second):
+		t.Fatal("worker was not woken when the work was eligible")
+	}
+	if v, b := q.Get(); !b || v != later.ID() {
+		t.Fatal(v)
+	}
+	q.Finish(later.ID())
+}
+
+// TestTask_Schedule ...
+func TestTask_Schedule(t *testing.T) {
+	var ran time.Time
+	name := testWorkType(t, NewStage("schedule", func(ctx context.Context, ep *Episode) error {
+		ran = time.Now()
+		return nil
+	}))
+	notBefore := time.Now().Add(200 * time.Millisecond)
+	work, e := NewWork(name, &VideoInfo{ID: tool.GenerateRandomString(8)}, VideoPathOption([]string{"schedule.mp4"}), NotBeforeOption(notBefore))
+	if e != nil {
+		t.Fatal(e)
+	}
+	task := NewTask()
+	if e := task.AddWorker(work, true); e != nil {
+		t.Fatal(e)
+	}
+	//the auto stopped task was held until the work was eligible
+	if e := task.Start(); e != nil {
+		t.Fatal(e)
+	}
+	if ran.Before(notBefore) {
+		t.Errorf("scheduled work was run at %v", ran)
+	}
+}
+
+// TestTask_Subscribe ...
+func TestTask_Subscribe(t *testing.T) {
+	name := testWorkType(t, NewStage("publish", func(ctx context.Context, ep *Episode) error {
+		return ep.Checkpoint("publish", "QmEvent")
+	}))
+	work, e := NewWork(name, &VideoInfo{ID: tool.GenerateRandomString(8)}, VideoPathOption([]string{"event.mp4"}))
+	if e != nil {
+		t.Fatal(e)
+	}
+	task := NewTask()
+	events, cancel := task.Subscribe(16)
+	defer cancel()
+	full, cancelFull := task.Subscribe(1, EventWorkAdded, EventWorkFinished)
+	defer cancelFull()
+	if e := task.AddWorker(work, true); e != nil {
+		t.Fatal(e)
+	}
+	if e := task.Start(); e != nil {
+		t.Fatal(e)
+	}
+	var types []string
+	for len(events) > 0 {
+		ev := <-events
+		types = append(types, ev.Type.String())
+		if ev.Type == EventStageCompleted && ev.Hash != "QmEvent" {
+			t.Errorf("stage hash = %s", ev.Hash)
+		}
+		if ev.Type == EventWorkFinished && len(ev.Videos) != 1 {
+			t.Errorf("finished videos = %d", len(ev.Videos))
+		}
+	}
+	if strings.Join(types, ",") != "added,started,stage_completed,finished" {
+		t.Errorf("events = %v", types)
+	}
+	//the full subscriber was not blocked the task
+	if len(full) != 1 || (<-full).Type != EventWorkAdded {
+		t.Error("event must be dropped when the subscriber was full")
+	}
+}
+
+// TestTask_FinishedVideos ...
+func TestTask_FinishedVideos(t *testing.T) {
+	var runs int32
+	name := testWorkType(t, NewStage("flaky", func(ctx context.Context, ep *Episode) error {
+		//the second episode was failed at the first run
+		if ep.Video().Episode == "2" && atomic.AddInt32(&runs, 1) == 1 {
+			return errors.New("node timeout")
+		}
+		return nil
+	}))
+	work, e := NewWork(name, &VideoInfo{ID: tool.GenerateRandomString(8)}, VideoPathOption([]string{"resume.S01E01.mp4", "resume.S01E02.mp4"}))
+	if e != nil {
+		t.Fatal(e)
+	}
+	task := NewTask()
+	task.RetryInterval = 10 * time.Millisecond
+	events, cancel := task.Subscribe(1, EventWorkFinished)
+	defer cancel()
+	if e := task.AddWorker(work, true); e != nil {
+		t.Fatal(e)
+	}
+	//the auto stopped task was held until the work was retried
+	if e := task.Start(); e != nil {
+		t.Fatal(e)
+	}
+	select {
+	case ev := <-events:
+		if len(ev.Videos) != 2 {
+			t.Errorf("finished videos = %d", len(ev.Videos))
+		}
+	default:
+		t.Errorf("work was not finished after %d runs", atomic.LoadInt32(&runs))
+	}
+}
+
+// TestTask_ClearTemp ...
+func TestTask_ClearTemp(t *testing.T) {
+	for _, clear := range []bool{false, true} {
+		dir, e := ioutil.TempDir("", "task_temp")
+		if e != nil {
+			t.Fatal(e)
+		}
+		defer os.RemoveAll(dir)
+		name := testWorkType(t, NewStage("temp", func(ctx context.Context, ep *Episode) error {
+			ep.Work().clearTemp(dir)
+			return nil
+		}))
+		work, e := NewWork(name, &VideoInfo{ID: tool.GenerateRandomString(8)}, VideoPathOption([]string{"temp.mp4"}))
+		if e != nil {
+			t.Fatal(e)
+		}
+		task := NewTask()
+		task.ClearTemp = clear
+		if e := task.AddWorker(work, true); e != nil {
+			t.Fatal(e)
+		}
+		if e := task.Start(); e != nil {
+			t.Fatal(e)
+		}
+		if _, e := os.Stat(dir); os.IsNotExist(e) != clear {
+			t.Errorf("task clear temp %v,but temp exist:%v", clear, e == nil)
+		}
+	}
+}
+
+// TestTask_AddRunning ...
+func TestTask_AddRunning(t *testing.T) {
+	name := testWorkType(t, NewStage("add", func(ctx context.Context, ep *Episode) error {
+		return nil
+	}))
+	task := NewTask()
+	task.SetAutoStop(false)
+	events, cancel := task.Subscribe(16, EventWorkAdded, EventWorkStarted, EventWorkFinished)
+	defer cancel()
+	go func() {
+		if e := task.Start(); e != nil {
+			t.Error(e)
+		}
+	}()
+	for i := 0; i < 100 && task.Workers() == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	work, e := NewWork(name, &VideoInfo{ID: tool.GenerateRandomString(8)}, VideoPathOption([]string{"add.mp4"}))
+	if e != nil {
+		t.Fatal(e)
+	}
+	if e := task.AddWorker(work, true); e != nil {
+		t.Fatal(e)
+	}
+	var types []string
+	for len(types) < 3 {
+		select {
+		case ev := <-events:
+			types = append(types, ev.Type.String())
+		case <-time.After(5 * time.Second):
+			t.Fatalf("events = %v", types)
+		}
+	}
+	if strings.Join(types, ",") != "added,started,finished" {
+		t.Errorf("events = %v", types)
+	}
+	if e := task.Shutdown(time.Second); e != nil {
+		t.Fatal(e)
+	}
+}
+
+// TestTask_Progress ...
+func TestTask_Progress(t *testing.T) {
+	running, release := make(chan struct{}), make(chan struct{})
+	name := testWorkType(t, NewStage("progress", func(ctx context.Context, ep *Episode) error {
+		close(running)
+		<-release
+		return nil
+	}))
+	work, e := NewWork(name, &VideoInfo{ID: tool.GenerateRandomString(8)}, VideoPathOption([]string{"progress.mp4"}))
+	if e != nil {
+		t.Fatal(e)
+	}
+	task := NewTask()
+	if e := task.AddWorker(work, true); e != nil {
+		t.Fatal(e)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if e := task.Start(); e != nil {
+			t.Error(e)
+		}
+	}()
+	<-running
+	p, b := task.GetProgress(work.ID())
+	if !b || p.ID != work.ID() || p.Stage != "progress" || p.Episode != 1 || p.TotalEpisode != 1 {
+		t.Errorf("wrong progress:%+v", p)
+	}
+	if ps := task.AllProgress(); len(ps) != 1 || ps[0].ID != work.ID() {
+		t.Errorf("all progress = %+v", ps)
+	}
+	close(release)
+	<-done
+	if _, b := task.GetProgress(work.ID()); b {
+		t.Error("finished work has no progress")
+	}
+}
+
+// TestTask_Webhook ...
+func TestTask_Webhook(t *testing.T) {
+	var calls int32
+	payloads := make(chan *WebhookPayload, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Header.Get(WebhookSignatureHeader) != Sign("secret", body) {
+			t.Error("wrong signature")
+		}
+		//failed at the first time to retry
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		var p WebhookPayload
+		if e := json.Unmarshal(body, &p); e != nil {
+			t.Error(e)
+		}
+		payloads <- &p
+	}))
+	defer server.Close()
+
+	name := testWorkType(t, NewStage("hash", func(ctx context.Context, ep *Episode) error {
+		ep.Video().No = ep.Work().ID()
+		h := ep.NewHash(HashTypeOther, "webhook", "QmWebhook")
+		h.Key = "secret key"
+		return insertHash(h)
+	}))
+	work, e := NewWork(name, &VideoInfo{ID: tool.GenerateRandomString(8)}, VideoPathOption([]string{"webhook.S01E03.mp4"}), WebhookOption(server.URL))
+	if e != nil {
+		t.Fatal(e)
+	}
+	task := NewTask()
+	task.WebhookSecret = "secret"
+	task.WebhookInterval = 10 * time.Millisecond
+	if e := task.AddWorker(work, true); e != nil {
+		t.Fatal(e)
+	}
+	if e := task.Start(); e != nil {
+		t.Fatal(e)
+	}
+	//the deliveries were finished when the task was ended
+	select {
+	case p := <-payloads:
+		if p.Event != "finished" || len(p.Videos) != 1 || len(p.Videos[0].Hashes) == 0 || p.Videos[0].Hashes[0].Hash != "QmWebhook" || p.Videos[0].Hashes[0].Key != "" {
+			t.Errorf("wrong payload:%+v", p)
+		}
+	default:
+		t.Fatal("webhook was not delivered")
+	}
+	deliveries, e := WebhookDeliveries(work.ID())
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(deliveries) < 2 || deliveries[0].Success || !deliveries[len(deliveries)-1].Success {
+		t.Errorf("wrong delivery log:%d", len(deliveries))
+	}
+}
@@ -0,0 +1,129 @@
+package conversion
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gocacher/cacher"
+)
+
+// DefaultTempRetentionKey ...
+var DefaultTempRetentionKey = "temp_retention"
+
+type keepTempKey struct{}
+
+// tempRetention the temp output waiting to be removed,it was persisted to survive a restart
+type tempRetention struct {
+	Path   string    `json:"path"`
+	Expire time.Time `json:"expire"`
+}
+
+var _tempLock = &sync.Mutex{}
+
+// withKeepTemp keep the temp output of the works run with the context
+func withKeepTemp(ctx context.Context, keep bool) context.Context {
+	return context.WithValue(ctx, keepTempKey{}, keep)
+}
+
+// keepTemp returns true if the task was set to keep the temp output
+func keepTemp(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	keep, _ := ctx.Value(keepTempKey{}).(bool)
+	return keep
+}
+
+// TempRetentionOption keep the temp output for debugging before it was removed
+func TempRetentionOption(d time.Duration) WorkOptions {
+	return func(impl *WorkImpl) {
+		impl.TempRetention = d
+	}
+}
+
+// clearTemp remove the temp output if the work was set to clear temp
+func (w Work) clearTemp(dir string) {
+	if dir == "" || !w.ClearTemp || keepTemp(w.ctx) {
+		return
+	}
+	if w.TempRetention > 0 {
+		retainTemp(dir, time.Now().Add(w.TempRetention))
+		return
+	}
+	removeTemp(dir)
+}
+
+func loadTempRetention() []tempRetention {
+	var list []tempRetention
+	bytes, e := cacher.Get(DefaultTempRetentionKey)
+	if e != nil {
+		return nil
+	}
+	if e := json.Unmarshal(bytes, &list); e != nil {
+		log.With("error", e).Error("temp retention unmarshal")
+		return nil
+	}
+	return list
+}
+
+// storeTempRetention must be called with lock
+func storeTempRetention(list []tempRetention) {
+	bytes, e := json.Marshal(list)
+	if e != nil {
+		log.With("error", e).Error("temp retention marshal")
+		return
+	}
+	if e := cacher.Set(DefaultTempRetentionKey, bytes); e != nil {
+		log.With("error", e).Error("temp retention persist")
+	}
+}
+
+// retainTemp remove the dir after expired
+func retainTemp(dir string, expire time.Time) {
+	_tempLock.Lock()
+	list := loadTempRetention()
+	storeTempRetention(append(list, tempRetention{Path: dir, Expire: expire}))
+	_tempLock.Unlock()
+	time.AfterFunc(time.Until(expire), func() {
+		removeTemp(dir)
+	})
+}
+
+func removeTemp(dir string) {
+	if e := os.RemoveAll(dir); e != nil {
+		log.With("path", dir, "error", e).Error("clear temp")
+		return
+	}
+	_tempLock.Lock()
+	defer _tempLock.Unlock()
+	list := loadTempRetention()
+	left := list[:0]
+	for _, r := range list {
+		if r.Path != dir {
+			left = append(left, r)
+		}
+	}
+	if len(left) != len(list) {
+		storeTempRetention(left)
+	}
+}
+
+// SweepTemp remove the expired temp output retained before restart and wait the others to be expired
+func SweepTemp() {
+	_tempLock.Lock()
+	list := loadTempRetention()
+	_tempLock.Unlock()
+	for _, r := range list {
+		dir := r.Path
+		if time.Now().After(r.Expire) {
+			removeTemp(dir)
+			continue
+		}
+		time.AfterFunc(time.Until(r.Expire), func() {
+			removeTemp(dir)
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package conversion
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ProblemNotExist ...
+const (
+	ProblemNotExist         = "not_exist"
+	ProblemNotVideo         = "not_video"
+	ProblemNotPicture       = "not_picture"
+	ProblemNotMedia         = "not_media"
+	ProblemProbeFailed      = "probe_failed"
+	ProblemDuplicateEpisode = "duplicate_episode"
+	ProblemUnknownStage     = "unknown_stage"
+)
+
+// Problem ...
+type Problem struct {
+	Field   string `json:"field"`   //字段名
+	Path    string `json:"path"`    //文件路径或值
+	Code    string `json:"code"`    //问题类型
+	Message string `json:"message"` //描述
+}
+
+// ValidationError ...
+type ValidationError struct {
+	ID       string    `json:"id"`
+	Problems []Problem `json:"problems"`
+}
+
+// Error ...
+func (e *ValidationError) Error() string {
+	var ss []string
+	for _, p := range e.Problems {
+		ss = append(ss, fmt.Sprintf("%s[%s]:%s", p.Field, p.Path, p.Code))
+	}
+	return fmt.Sprintf("work[%s] invalid:%s", e.ID, strings.Join(ss, ","))
+}
+
+// Validate check the files,probe results and episodes of work before it was run
+func (w *Work) Validate() []Problem {
+	var problems []Problem
+	add := func(field, path, code, msg string) {
+		problems = append(problems, Problem{
+			Field:   field,
+			Path:    path,
+			Code:    code,
+			Message: msg,
+		})
+	}
+	episodes := make(map[string]string)
+	for _, path := range w.VideoPaths {
+		if path == "" {
+			continue
+		}
+		if msg := fileProblem(path); msg != "" {
+			add("video_paths", path, ProblemNotExist, msg)
+			continue
+		}
+		if !isExt(path, IsVideo) {
+			add("video_paths", path, ProblemNotVideo, "unsupported video extension")
+		}
+		key := ParseEpisode(path).Key()
+		if p, b := episodes[key]; b {
+			add("video_paths", path, ProblemDuplicateEpisode, "same episode "+key+" with "+p)
+		}
+		episodes[key] = path
+		if _ffprobe == nil {
+			continue
+		}
+		format, e := _ffprobe.StreamFormat(path)
+		if e != nil {
+			add("video_paths", path, ProblemProbeFailed, e.Error())
+			continue
+		}
+		if !IsMedia(format) {
+			add("video_paths", path, ProblemNotMedia, ErrNotMedia.Error())
+		}
+	}
+	pictures := map[string][]string{
+		"poster_path": {w.PosterPath},
+		"thumb_path":  {w.ThumbPath},
+		"sample_path": w.SamplePath,
+	}
+	for _, field := range []string{"poster_path", "thumb_path", "sample_path"} {
+		for _, path := range pictures[field] {
+			if path == "" {
+				continue
+			}
+			if msg := fileProblem(path); msg != "" {
+				add(field, path, ProblemNotExist, msg)
+				continue
+			}
+			if !isExt(path, IsPicture) {
+				add(field, path, ProblemNotPicture, "unsupported picture extension")
+			}
+		}
+	}
+	for _, s := range w.Skip {
+		if err := ValidateSkip(w.WorkType, s); err != nil {
+			add("skip", s, ProblemUnknownStage, err.Error())
+		}
+	}
+	return problems
+}
+
+// fileProblem returns the message if the path was not a readable file
+func fileProblem(path string) string {
+	info, e := os.Stat(path)
+	if e != nil {
+		return e.Error()
+	}
+	if info.IsDir() {
+		return "path is a directory"
+	}
+	f, e := os.Open(path)
+	if e != nil {
+		return e.Error()
+	}
+	_ = f.Close()
+	return ""
+}
+
+func isExt(path string, f func(string) bool) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext != "" && f(ext)
+}
+
+// Validate ...
+func (t *Task) Validate(work IWork) error {
+	problems := work.Work().Validate()
+	if len(problems) == 0 {
+		return nil
+	}
+	return Permanent(&ValidationError{
+		ID:       work.ID(),
+		Problems: problems,
+	})
+}
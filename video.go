@@ -5,43 +5,52 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"io"
 	"os"
+
+	"github.com/xormsharp/xorm"
 )
 
 // Video ...
 type Video struct {
-	Model        `xorm:"extends" json:"-"`
-	No           string   `xorm:"no" json:"no"`                       //编号
-	Intro        string   `xorm:"varchar(2048)" json:"intro"`         //简介
-	Alias        []string `xorm:"json" json:"alias"`                  //别名，片名
-	ThumbHash    string   `xorm:"thumb_hash" json:"thumb_hash"`       //缩略图
-	PosterHash   string   `xorm:"poster_hash" json:"poster_hash"`     //海报地址
-	SourceHash   string   `xorm:"source_hash" json:"source_hash"`     //原片地址
-	M3U8Hash     string   `xorm:"m3u8_hash" json:"m3u8_hash"`         //切片地址
-	Key          string   `xorm:"key"  json:"-"`                      //秘钥
-	M3U8         string   `xorm:"m3u8" json:"-"`                      //M3U8名
-	Role         []string `xorm:"json" json:"role"`                   //主演
-	Director     string   `xorm:"director" json:"director"`           //导演
-	Systematics  string   `xorm:"systematics" json:"systematics"`     //分级
-	Season       string   `xorm:"season" json:"season"`               //季
-	TotalEpisode string   `xorm:"total_episode" json:"total_episode"` //总集数
-	Episode      string   `xorm:"episode" json:"episode"`             //集数
-	Producer     string   `xorm:"producer" json:"producer"`           //生产商
-	Publisher    string   `xorm:"publisher" json:"publisher"`         //发行商
-	Type         string   `xorm:"type" json:"type"`                   //类型：film，FanDrama
-	Format       string   `xorm:"format" json:"format"`               //输出格式：3D，2D,VR(VR格式：Half-SBS：左右半宽,Half-OU：上下半高,SBS：左右全宽)
-	Language     string   `xorm:"language" json:"language"`           //语言
-	Caption      string   `xorm:"caption" json:"caption"`             //字幕
-	Group        string   `xorm:"group" json:"-"`                     //分组
-	Index        string   `xorm:"index" json:"-"`                     //索引
-	Date         string   `xorm:"'date'" json:"date"`                 //发行日期
-	Sharpness    string   `xorm:"sharpness" json:"sharpness"`         //清晰度
-	Series       string   `xorm:"series" json:"series"`               //系列
-	Tags         []string `xorm:"json tags" json:"tags"`              //标签
-	Length       string   `xorm:"length" json:"length"`               //时长
-	Sample       []string `xorm:"json sample" json:"sample"`          //样板图
-	Uncensored   bool     `xorm:"uncensored" json:"uncensored"`       //有码,无码
+	Model         `xorm:"extends" json:"-"`
+	No            string   `xorm:"no" json:"no"`                              //编号
+	Intro         string   `xorm:"varchar(2048)" json:"intro"`                //简介
+	Alias         []string `xorm:"json" json:"alias"`                         //别名，片名
+	ThumbHash     string   `xorm:"thumb_hash" json:"thumb_hash"`              //缩略图
+	PosterHash    string   `xorm:"poster_hash" json:"poster_hash"`            //海报地址
+	SourceHash    string   `xorm:"source_hash" json:"source_hash"`            //原片地址
+	M3U8Hash      string   `xorm:"m3u8_hash" json:"m3u8_hash"`                //切片地址
+	Key           string   `xorm:"key"  json:"-"`                             //秘钥
+	M3U8          string   `xorm:"m3u8" json:"-"`                             //M3U8名
+	Role          []string `xorm:"json" json:"role"`                          //主演
+	Director      string   `xorm:"director" json:"director"`                  //导演
+	Systematics   string   `xorm:"systematics" json:"systematics"`            //分级
+	Season        string   `xorm:"season" json:"season"`                      //季
+	TotalEpisode  string   `xorm:"total_episode" json:"total_episode"`        //总集数
+	Episode       string   `xorm:"episode" json:"episode"`                    //集数
+	Producer      string   `xorm:"producer" json:"producer"`                  //生产商
+	Publisher     string   `xorm:"publisher" json:"publisher"`                //发行商
+	Type          string   `xorm:"type" json:"type"`                          //类型：film，FanDrama
+	Format        string   `xorm:"format" json:"format"`                      //输出格式：3D，2D,VR(VR格式：Half-SBS：左右半宽,Half-OU：上下半高,SBS：左右全宽)
+	Language      string   `xorm:"language" json:"language"`                  //语言
+	Caption       string   `xorm:"caption" json:"caption"`                    //字幕
+	Group         string   `xorm:"group" json:"-"`                            //分组
+	Index         string   `xorm:"index" json:"-"`                            //索引
+	Date          string   `xorm:"'date'" json:"date"`                        //发行日期
+	Sharpness     string   `xorm:"sharpness" json:"sharpness"`                //清晰度
+	Series        string   `xorm:"series" json:"series"`                      //系列
+	Tags          []string `xorm:"json tags" json:"tags"`                     //标签
+	Length        string   `xorm:"length" json:"length"`                      //时长
+	Sample        []string `xorm:"json sample" json:"sample"`                 //样板图
+	Uncensored    bool     `xorm:"uncensored" json:"uncensored"`              //有码,无码
+	Resolution    string   `xorm:"resolution" json:"resolution"`              //分辨率
+	Codec         string   `xorm:"codec" json:"codec"`                        //视频编码
+	AudioCodec    string   `xorm:"audio_codec" json:"audio_codec"`            //音频编码
+	BitRate       int64    `xorm:"bit_rate" json:"bit_rate"`                  //码率
+	FrameRate     string   `xorm:"frame_rate" json:"frame_rate"`              //帧率
+	AudioLanguage []string `xorm:"json audio_language" json:"audio_language"` //音轨语言
 }
 
 func init() {
@@ -77,6 +86,9 @@ func (v *Video) BeforeInsert() {
 	if len(v.Sample) == 0 {
 		v.Sample = []string{}
 	}
+	if len(v.AudioLanguage) == 0 {
+		v.AudioLanguage = []string{}
+	}
 }
 
 // Sync ...
@@ -89,6 +101,16 @@ func (v *Video) Table() interface{} {
 	return &Video{}
 }
 
+// FindVideo ...
+func FindVideo(session *xorm.Session, id string) (*Video, error) {
+	v := new(Video)
+	b, e := MustSession(session).ID(id).Get(v)
+	if e != nil || !b {
+		return nil, errors.New("video not found")
+	}
+	return v, nil
+}
+
 // IVideo ...
 type IVideo interface {
 	Video() *Video
@@ -94,20 +94,9 @@ func (v VideoInfo) Video() *Video {
 
 // NewInfoWork ...
 func NewInfoWork(info *VideoInfo, options ...WorkOptions) (IWork, error) {
-	bys, e := json.Marshal(info)
-	if e != nil {
-		return nil, e
-	}
-
-	options = append(options, IDOption(info.ID))
-	work := newWork("info", defaultWork(options...), bys)
-
-	if work.ID() == "" {
-		return nil, ErrWorkID
-	}
-
-	return work, nil
+	return NewWork("info", info, options...)
 }
+
 func decodeInfo(src []byte) (IVideo, error) {
 	var info VideoInfo
 	e := json.Unmarshal(src, &info)
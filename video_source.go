@@ -18,6 +18,7 @@ type VideoSource struct {
 	SourceHash string    `json:"source_hash"` //原片hash
 	ThumbPath  string    `json:"thumb_path"`  //缩略图路径
 	PosterPath string    `json:"poster_path"` //海报路径
+	SamplePath []string  `json:"sample_path"` //样板图路径
 	Format     string    `json:"format"`      //输出：3D，2D
 	Thumb      string    `json:"thumb"`       //缩略图HASH
 	Poster     string    `json:"poster"`      //海报HASH
@@ -39,17 +40,19 @@ type VideoSource struct {
 
 // NewSourceWork ...
 func NewSourceWork(source *VideoSource, options ...WorkOptions) (IWork, error) {
-	bys, e := json.Marshal(source)
-	if e != nil {
-		return nil, e
+	return NewWork("source", source, options...)
+}
+
+func constructSource(video IVideo) []WorkOptions {
+	source, b := video.(*VideoSource)
+	if !b {
+		return nil
 	}
-	opts := []WorkOptions{IDOption(source.Bangumi),
+	return []WorkOptions{
 		VideoPathOption(source.VideoPath),
 		PosterPathOption(source.PosterPath),
+		SamplePathOption(source.SamplePath),
 		ThumbPathOption(source.Thumb)}
-	opts = append(opts, options...)
-	work := newWork("source", defaultWork(opts...), bys)
-	return work, nil
 }
 
 func decodeSource(src []byte) (IVideo, error) {
@@ -0,0 +1,217 @@
+package conversion
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// WebhookSignatureHeader ...
+const (
+	WebhookSignatureHeader = "X-Conversion-Signature"
+	WebhookEventHeader     = "X-Conversion-Event"
+)
+
+// DefaultWebhookAttempts ...
+var DefaultWebhookAttempts = 5
+
+// DefaultWebhookInterval ...
+var DefaultWebhookInterval = 5 * time.Second
+
+// DefaultWebhookTimeout ...
+var DefaultWebhookTimeout = 30 * time.Second
+
+// WebhookPayload ...
+type WebhookPayload struct {
+	Event  string          `json:"event"`
+	ID     string          `json:"id"`
+	Time   time.Time       `json:"time"`
+	Error  string          `json:"error,omitempty"`
+	Videos []*WebhookVideo `json:"videos"`
+}
+
+// WebhookVideo ...
+type WebhookVideo struct {
+	Video  *Video  `json:"video"`
+	Hashes []*Hash `json:"hashes"`
+}
+
+// WebhookDelivery the delivery log of webhooks
+type WebhookDelivery struct {
+	Model      `xorm:"extends"`
+	WorkID     string `xorm:"work_id" json:"work_id"`           //任务ID
+	Event      string `xorm:"event" json:"event"`               //事件
+	URL        string `xorm:"varchar(1024) url" json:"url"`     //地址
+	Attempt    int    `xorm:"attempt" json:"attempt"`           //第几次发送
+	StatusCode int    `xorm:"status_code" json:"status_code"`   //返回状态
+	Error      string `xorm:"varchar(1024) error" json:"error"` //错误
+	Success    bool   `xorm:"success" json:"success"`           //成功
+}
+
+func init() {
+	registerTable(&WebhookDelivery{})
+}
+
+// Table ...
+func (d *WebhookDelivery) Table() interface{} {
+	return &WebhookDelivery{}
+}
+
+// Sync ...
+func (d *WebhookDelivery) Sync() error {
+	return _database.Sync2(d)
+}
+
+// WebhookOption the urls was notified when the work finished or failed
+func WebhookOption(urls ...string) WorkOptions {
+	return func(impl *WorkImpl) {
+		impl.Webhooks = urls
+	}
+}
+
+// WebhookDeliveries ...
+func WebhookDeliveries(workID string) ([]*WebhookDelivery, error) {
+	var deliveries []*WebhookDelivery
+	if e := _database.Where("work_id = ?", workID).Asc("created_at", "attempt").Find(&deliveries); e != nil {
+		return nil, e
+	}
+	return deliveries, nil
+}
+
+// Sign returns the hex hmac-sha256 of body
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// notify publish the finished and failed events,
+// they were sent to webhooks without the event bus which drops events,the task waits the deliveries before end
+func (t *Task) notify(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	t.events.publish(e)
+	t.hooks.Add(1)
+	go func() {
+		defer t.hooks.Done()
+		t.webhook(e)
+	}()
+}
+
+// webhook send the finished and failed events to the global and work urls
+func (t *Task) webhook(e Event) {
+	urls := append([]string{}, t.Webhooks...)
+	if work, err := LoadWork(e.ID); err == nil {
+		urls = append(urls, work.Work().Webhooks...)
+	}
+	if len(urls) == 0 {
+		return
+	}
+	payload, err := newWebhookPayload(e)
+	if err != nil {
+		log.With("id", e.ID, "error", err).Error("webhook payload")
+		return
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.With("id", e.ID, "error", err).Error("webhook payload")
+		return
+	}
+	for _, url := range urls {
+		t.hooks.Add(1)
+		go func(url string) {
+			defer t.hooks.Done()
+			t.deliver(e, url, body)
+		}(url)
+	}
+}
+
+func newWebhookPayload(e Event) (*WebhookPayload, error) {
+	payload := &WebhookPayload{
+		Event:  e.Type.String(),
+		ID:     e.ID,
+		Time:   e.Time,
+		Error:  e.Error,
+		Videos: []*WebhookVideo{},
+	}
+	for _, v := range e.Videos {
+		hashes, err := AllHash(_database.Where("name = ? AND season = ? AND episode = ?", v.No, v.Season, v.Episode), 0)
+		if err != nil {
+			return nil, Wrap(err, "find hashes")
+		}
+		for _, h := range *hashes {
+			//the key of encrypted slices must not be sent out as the video did
+			h.Key = ""
+		}
+		payload.Videos = append(payload.Videos, &WebhookVideo{
+			Video:  v,
+			Hashes: *hashes,
+		})
+	}
+	return payload, nil
+}
+
+// deliver post the body until success or attempts exhausted with backoff
+func (t *Task) deliver(e Event, url string, body []byte) {
+	interval := t.WebhookInterval
+	for attempt := 1; attempt <= t.WebhookAttempts; attempt++ {
+		d := &WebhookDelivery{
+			WorkID:  e.ID,
+			Event:   e.Type.String(),
+			URL:     url,
+			Attempt: attempt,
+		}
+		code, err := t.post(url, e, body)
+		d.StatusCode, d.Success = code, err == nil
+		if err != nil {
+			d.Error = err.Error()
+		}
+		if _, err := InsertOrUpdate(d); err != nil {
+			log.With("id", e.ID, "url", url, "error", err).Error("webhook delivery log")
+		}
+		if d.Success || attempt == t.WebhookAttempts {
+			return
+		}
+		log.With("id", e.ID, "url", url, "attempt", attempt, "error", d.Error).Warn("webhook retry")
+		select {
+		case <-time.After(interval):
+		case <-t.context.Done():
+			return
+		}
+		interval *= 2
+	}
+}
+
+// post returns the status code,error if it was not 2xx
+func (t *Task) post(url string, e Event, body []byte) (int, error) {
+	ctx, cancel := context.WithTimeout(t.context, DefaultWebhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookEventHeader, e.Type.String())
+	if t.WebhookSecret != "" {
+		req.Header.Set(WebhookSignatureHeader, Sign(t.WebhookSecret, body))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("webhook status:%s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
@@ -6,11 +6,13 @@ import (
 	"errors"
 	"fmt"
 	"github.com/glvd/go-fftool"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
-	"unicode"
+	"sync"
+	"time"
 
 	"github.com/gocacher/cacher"
 )
@@ -22,6 +24,7 @@ const (
 	WorkRunning
 	WorkStopped
 	WorkFinish
+	WorkFailed
 )
 
 // RelateList ...
@@ -32,23 +35,44 @@ type WorkStatus int
 
 // WorkImpl ...
 type WorkImpl struct {
-	ID         string
-	Status     WorkStatus
-	VideoPaths []string
-	PosterPath string
-	ThumbPath  string
-	SamplePath []string
-	Crypto     *Crypto
-	Scale      Scale
-	Output     string
-	Skip       []string
-	ClearTemp  bool
+	ID            string
+	Status        WorkStatus
+	VideoPaths    []string
+	PosterPath    string
+	ThumbPath     string
+	SamplePath    []string
+	SampleDir     bool
+	Frame         *FrameConfig
+	Subtitle      bool
+	Crypto        *Crypto
+	Scale         Scale
+	Scales        []Scale
+	AutoScale     bool
+	Output        string
+	Skip          []string
+	ClearTemp     bool
+	TempRetention time.Duration //临时文件保留时间
+	Attempt       int
+	Priority      int      //优先级,越大越先执行
+	Schedule      Schedule //定时执行
+	Webhooks      []string //完成或失败时通知的地址
+	Checkpoints   map[string]*Checkpoint
+	LastError     string        //最后一次错误,重试成功后保留
+	ErrorStage    string        //出错步骤
+	ErrorEpisode  string        //出错集数
+	CreatedAt     time.Time     //创建时间
+	StartedAt     time.Time     //最后一次开始时间
+	CompletedAt   time.Time     //完成时间
+	StageHistory  []StageRecord //步骤完成记录
 }
 
 // Work ...
 type Work struct {
-	ctx    context.Context
-	cancel context.CancelFunc
+	ctx      context.Context
+	cancel   context.CancelFunc
+	lock     *sync.RWMutex
+	progress *progress
+	videos   []*Video
 	*WorkImpl
 	WorkType string
 	Value    []byte
@@ -63,7 +87,12 @@ type IWork interface {
 	Update() error
 	Store() error
 	Reset() error
+	Clear() error
 	Status() WorkStatus
+	Attempt() int
+	Priority() int
+	Fail(e error) error
+	Progress() Progress
 	Run(ctx context.Context) (e error)
 	Stop() error
 }
@@ -84,6 +113,7 @@ var ErrWorkID = errors.New("video id must input")
 var ErrWrongCastType = errors.New("something wrong when cast to type")
 
 // WorkRunProcessFunction ...
+// Deprecated: use RegisterWorkType, decoders added here are still found by the work type
 var WorkRunProcessFunction = map[string]VideoProcessFunc{
 	"source": decodeSource,
 	"info":   decodeInfo,
@@ -110,6 +140,20 @@ func ScaleOption(scale Scale) WorkOptions {
 	}
 }
 
+// ScalesOption ...
+func ScalesOption(scales ...Scale) WorkOptions {
+	return func(impl *WorkImpl) {
+		impl.Scales = scales
+	}
+}
+
+// AutoScaleOption ...
+func AutoScaleOption(b bool) WorkOptions {
+	return func(impl *WorkImpl) {
+		impl.AutoScale = b
+	}
+}
+
 // OutputPathOption ...
 func OutputPathOption(path string) WorkOptions {
 	return func(impl *WorkImpl) {
@@ -176,6 +220,9 @@ func defaultWork(options ...WorkOptions) *WorkImpl {
 		Output:     os.TempDir(),
 		Skip:       nil,
 		ClearTemp:  true,
+		Frame:      DefaultFrameConfig(),
+		Subtitle:   true,
+		CreatedAt:  time.Now(),
 	}
 	for _, opt := range options {
 		opt(impl)
@@ -185,6 +232,8 @@ func defaultWork(options ...WorkOptions) *WorkImpl {
 
 func newWork(wt string, impl *WorkImpl, val []byte) *Work {
 	return &Work{
+		lock:     &sync.RWMutex{},
+		progress: newProgress(impl.ID),
 		WorkImpl: impl,
 		WorkType: wt,
 		Value:    val,
@@ -193,58 +242,235 @@ func newWork(wt string, impl *WorkImpl, val []byte) *Work {
 
 // Reset ...
 func (w *Work) Reset() error {
+	//restart by manual after stopped or failed
+	if w.WorkImpl.Status == WorkStopped || w.WorkImpl.Status == WorkFailed {
+		w.WorkImpl.Attempt = 0
+	}
 	w.WorkImpl.Status = WorkWaiting
 	return w.Update()
 }
 
+// Attempt ...
+func (w Work) Attempt() int {
+	return w.WorkImpl.Attempt
+}
+
+// Fail ...
+func (w *Work) Fail(e error) error {
+	//the kept slice output will not be resumed
+	w.clearFragments()
+	w.WorkImpl.Status = WorkFailed
+	if e != nil {
+		w.lock.Lock()
+		w.LastError = e.Error()
+		w.lock.Unlock()
+	}
+	return w.Update()
+}
+
 // Status ...
 func (w Work) Status() WorkStatus {
 	return w.WorkImpl.Status
 }
 
-func (w Work) slice(ctx context.Context, input string) (*Fragment, error) {
-	format, e := _ffprobe.StreamFormat(input)
+func (w Work) slice(ctx context.Context, ep *Episode) (f *Fragment, err error) {
+	input := ep.path
+	format, e := ep.Probe()
 	//format, e := split.FFProbeStreamFormat(input)
 	if e != nil {
-		return nil, Wrap(e, "probe error")
+		return nil, e
 	}
 	if !IsMedia(format) {
-		return nil, errors.New("file is not a video/audio")
+		return nil, ErrNotMedia
+	}
+	release, e := acquire(ctx, ResourceCPU)
+	if e != nil {
+		return nil, e
+	}
+	defer release()
+	output, e := ioutil.TempDir(w.Output(), w.ID()+"_")
+	if e != nil {
+		return nil, Wrap(e, "slice output")
 	}
+	defer func() {
+		//remove the partial output when failed or canceled
+		if err != nil {
+			w.clearTemp(output)
+		}
+	}()
+	duration := ep.Duration()
+	watch, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if !w.ladder() {
+		go w.progress.watchSlice(watch, output, fftool.DefaultHLSTime, duration)
+		r, path, e := w.sliceScale(ctx, input, format, output, w.WorkImpl.Scale)
+		//sa, e := split.FFMpegSplitToM3U8(ctx, input, split.StreamFormatOption(format), split.ScaleOption(formatScale(w.Scale)), split.OutputOption(w.Output()), split.AutoOption(true))
+		if e != nil {
+			return nil, Wrap(e)
+		}
+		w.progress.percent(100)
+		subs, e := w.subtitle(ctx, ep, path)
+		if e != nil {
+			return nil, Wrap(e, "run subtitle")
+		}
+		m3u8 := r.M3U8
+		if subs != nil {
+			m3u8 = DefaultMasterName
+			if e := writeMaster(filepath.Join(path, m3u8), []*Rendition{r}, subs); e != nil {
+				return nil, Wrap(e, "write master")
+			}
+		}
+		return &Fragment{
+			scale:      r.Scale,
+			temp:       output,
+			output:     path,
+			skip:       w.Skip,
+			input:      input,
+			sharpness:  r.Sharpness,
+			m3u8:       m3u8,
+			renditions: []*Rendition{r},
+			subtitles:  subs,
+		}, nil
+	}
+
+	var height int64
+	if v := format.Video(); v.Height != nil {
+		height = *v.Height
+	}
+	scales := ladderScales(height, w.Scales...)
+	go w.progress.watchSlice(watch, output, fftool.DefaultHLSTime, duration*float64(len(scales)))
+	var renditions []*Rendition
+	for _, scale := range scales {
+		r, path, e := w.sliceScale(ctx, input, format, output, scale)
+		if e != nil {
+			return nil, Wrap(e, "slice "+Sharpness(scale))
+		}
+		//rename the process dir to sharpness for the master playlist
+		if e := os.Rename(path, filepath.Join(output, r.Sharpness)); e != nil {
+			return nil, Wrap(e, "rename rendition")
+		}
+		r.M3U8 = filepath.Join(r.Sharpness, r.M3U8)
+		renditions = append(renditions, r)
+	}
+	w.progress.percent(100)
+	subs, e := w.subtitle(ctx, ep, output)
+	if e != nil {
+		return nil, Wrap(e, "run subtitle")
+	}
+	if e := writeMaster(filepath.Join(output, DefaultMasterName), renditions, subs); e != nil {
+		return nil, Wrap(e, "write master")
+	}
+	return &Fragment{
+		scale:      renditions[0].Scale,
+		temp:       output,
+		output:     output,
+		skip:       w.Skip,
+		input:      input,
+		sharpness:  joinSharpness(renditions),
+		m3u8:       DefaultMasterName,
+		renditions: renditions,
+		subtitles:  subs,
+	}, nil
+}
+
+func (w Work) key() string {
+	if w.Crypto != nil {
+		return w.Crypto.Key
+	}
+	return ""
+}
+
+func (w Work) dedupSlice(ep *Episode) ([]*Hash, error) {
+	renditions, e := w.expectRenditions(ep)
+	if e != nil {
+		return nil, e
+	}
+	return FindSliceHash(ep.Checksum(), w.Crypto != nil, w.key(), renditions)
+}
+
+func (w Work) m3u8(hashes []*Hash, captions []*Hash) string {
+	if w.ladder() || len(captions) > 0 {
+		return DefaultMasterName
+	}
+	return hashes[0].M3U8
+}
+
+func (w Work) ladder() bool {
+	return w.AutoScale || len(w.Scales) > 0
+}
+
+// sliceScale run ffmpeg with one scale and returns the rendition with the process path
+func (w Work) sliceScale(ctx context.Context, input string, format *fftool.StreamFormat, output string, scale Scale) (*Rendition, string, error) {
 	cfg := fftool.DefaultConfig()
 	cfg.SetSlice(true)
-	cfg.OutputPath = w.Output()
-	cfg.Scale = w.WorkImpl.Scale
+	cfg.OutputPath = output
+	cfg.Scale = scale
 	if w.Crypto != nil {
 		cfg.SetCrypt(*w.Crypto)
 	}
 
-	sharpness := fmt.Sprintf("%dP", fftool.ScaleValue(w.WorkImpl.Scale))
+	var height int64
+	video := format.Video()
+	if video.Height != nil {
+		height = *video.Height
+	}
+	if scale > sourceScale(height) {
+		scale = sourceScale(height)
+	}
 	ff := fftool.NewFFMpeg(cfg)
+	if video.CodecName == "h264" && scale == fftool.Scale480P {
+		//fftool copies the h264 stream at 480P but keeps the scale filter which ffmpeg rejects,encode it without optimizing
+		source, _ := strconv.ParseInt(video.BitRate, 10, 64)
+		cfg.Scale = scale
+		cfg.BitRate = scaleBitRate(scale, source)
+	} else {
+		ff = ff.OptimizeWithFormat(format)
+	}
 
-	ff = ff.OptimizeWithFormat(format)
+	e := ff.Run(ctx, input)
+	if e != nil {
+		return nil, "", e
+	}
+	//the process id was generated by ffmpeg run
+	processed := ff.Config()
+	return newRendition(processed, format), processed.ProcessPath(), nil
+}
 
-	e = ff.Run(ctx, input)
-	//sa, e := split.FFMpegSplitToM3U8(ctx, input, split.StreamFormatOption(format), split.ScaleOption(formatScale(w.Scale)), split.OutputOption(w.Output()), split.AutoOption(true))
+func (w Work) AddFile(ctx context.Context, path string) (string, error) {
+	release, e := acquire(ctx, ResourceNetwork)
+	if e != nil {
+		return "", e
+	}
+	defer release()
+	s, e := globalNode.AddFile(ctx, path)
 	if e != nil {
-		return nil, Wrap(e)
+		return "", e
 	}
+	w.progress.uploaded(FileSize(path))
+	return s, nil
+}
 
-	return &Fragment{
-		scale:     cfg.Scale,
-		output:    cfg.ProcessPath(),
-		skip:      w.Skip,
-		input:     input,
-		sharpness: sharpness,
-	}, nil
+func (w Work) AddDir(ctx context.Context, dir string) (string, error) {
+	release, e := acquire(ctx, ResourceNetwork)
+	if e != nil {
+		return "", e
+	}
+	defer release()
+	s, e := globalNode.AddDir(ctx, dir)
+	if e != nil {
+		return "", e
+	}
+	w.progress.uploaded(FileSize(dir))
+	return s, nil
 }
 
 func (w Work) video() (IVideo, error) {
-	fn, b := WorkRunProcessFunction[w.WorkType]
-	if !b {
-		return nil, errors.New("work type not found")
+	wt, e := getWorkType(w.WorkType)
+	if e != nil {
+		return nil, e
 	}
-	return fn(w.Value)
+	return wt.decode(w.Value)
 }
 
 // LoadWork ...
@@ -255,7 +481,15 @@ func LoadWork(id string) (IWork, error) {
 	}
 	var w Work
 	e = json.Unmarshal(bytes, &w)
-	return &w, e
+	if e != nil {
+		return nil, e
+	}
+	if _, err := getWorkType(w.WorkType); err != nil {
+		return nil, Wrap(err, "load work["+id+"]")
+	}
+	w.lock = &sync.RWMutex{}
+	w.progress = newProgress(w.ID())
+	return &w, nil
 }
 
 // ID ...
@@ -268,9 +502,20 @@ func (w Work) Output() string {
 	return w.WorkImpl.Output
 }
 
+// Progress ...
+func (w Work) Progress() Progress {
+	return w.progress.Load()
+}
+
+func (w *Work) marshal() ([]byte, error) {
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+	return json.Marshal(w)
+}
+
 // Store ...
 func (w *Work) Store() error {
-	bytes, e := json.Marshal(w)
+	bytes, e := w.marshal()
 	if e != nil {
 		return e
 	}
@@ -287,7 +532,7 @@ func (w *Work) Store() error {
 
 // Update ...
 func (w *Work) Update() error {
-	bytes, e := json.Marshal(w)
+	bytes, e := w.marshal()
 	if e != nil {
 		return e
 	}
@@ -321,6 +566,9 @@ func (w Work) CheckStop(f func() error) error {
 	case <-w.ctx.Done():
 		return w.ctx.Err()
 	default:
+		if draining(w.ctx) {
+			return ErrDraining
+		}
 		return f()
 	}
 }
@@ -330,86 +578,87 @@ func (w *Work) Run(ctx context.Context) (e error) {
 	w.ctx, w.cancel = context.WithCancel(ctx)
 	defer w.cancel()
 	w.WorkImpl.Status = WorkRunning
+	w.WorkImpl.Attempt++
+	w.started()
 	if err := w.Update(); err != nil {
 		return Wrap(err, "run update")
 	}
 	v, e := w.video()
 	if e != nil {
+		w.failedAt("", "", e)
 		return Wrap(e, "run video")
 	}
+	pipeline, e := w.pipeline()
+	if e != nil {
+		w.failedAt("", "", e)
+		return Wrap(e, "run pipeline")
+	}
+	if e := checkEpisodes(w.VideoPaths...); e != nil {
+		w.failedAt("", "", e)
+		return e
+	}
+	w.progress.reset(len(w.VideoPaths))
 	for _, path := range w.VideoPaths {
 		if path == "" {
 			continue
 		}
 
-		video := v.Video()
-		video.TotalEpisode = strconv.Itoa(len(w.VideoPaths))
-		video.Episode = strconv.Itoa(GetFileIndex(path))
-		if err := w.CheckStop(func() error {
-			if !ExistVerifyString("source", w.Skip...) {
-				s, e := globalNode.AddFile(ctx, path)
-				if e != nil {
-					return Wrap(e, "add source")
-				}
-				video.SourceHash = s
+		ep := newEpisode(w, path, len(w.VideoPaths), v.Video())
+		if w.episodeFinished(ep.name) {
+			log.With("id", w.ID(), "episode", ep.name).Info("episode was finished")
+			//the finished episodes were reported with the work
+			video, err := w.finishedVideo(ep)
+			if err != nil {
+				log.With("id", w.ID(), "episode", ep.name, "error", err).Warn("load finished video")
+				continue
 			}
-			return nil
-		}); err != nil {
-			return err
+			w.videos = append(w.videos, video)
+			continue
 		}
-
-		if err := w.CheckStop(func() error {
-			if !ExistVerifyString("slice", w.Skip...) {
-				f, e := w.slice(ctx, path)
-				if e != nil {
-					return Wrap(e, "run slice")
+		for _, stage := range pipeline {
+			if err := w.CheckStop(func() error {
+				if ExistVerifyString(stage.Name(), w.Skip...) {
+					return nil
 				}
-				s, e := globalNode.AddDir(ctx, f.Output())
-				if e != nil {
-					return Wrap(e, "add slice")
+				w.progress.stage(ep.index, stage.Name())
+				if err := stage.Execute(w.ctx, ep); err != nil {
+					return err
 				}
-				video.M3U8Hash = s
+				w.stageFinished(ep.name, stage.Name())
+				hash, _ := w.Finished(ep.name, stage.Name())
+				emit(w.ctx, Event{
+					Type:    EventStageCompleted,
+					ID:      w.ID(),
+					Episode: ep.name,
+					Stage:   stage.Name(),
+					Hash:    hash,
+				})
+				return nil
+			}); err != nil {
+				w.failedAt(ep.name, stage.Name(), err)
+				return err
 			}
-			return nil
-		}); err != nil {
-			return err
 		}
 
-		if err := w.CheckStop(func() error {
-			if !ExistVerifyString("poster", w.Skip...) && w.PosterPath != "" {
-				s, e := globalNode.AddFile(ctx, w.PosterPath)
-				if e != nil {
-					return Wrap(e, "add poster")
-				}
-				video.PosterHash = s
-			}
-			return nil
-		}); err != nil {
-			return err
-		}
-		if err := w.CheckStop(func() error {
-			if !ExistVerifyString("thumb", w.Skip...) && w.ThumbPath != "" {
-				s, e := globalNode.AddFile(ctx, w.ThumbPath)
-				if e != nil {
-					return Wrap(e, "add thumb")
-				}
-				video.ThumbHash = s
-			}
-			return nil
-		}); err != nil {
-			return err
-		}
-
-		i, e := InsertOrUpdate(video)
+		i, e := InsertOrUpdate(ep.video)
 		if e != nil {
+			w.failedAt(ep.name, "", e)
 			return Wrap(e)
 		}
 		if i == 0 {
-			log.With("id", video.ID()).Warn("not updated")
+			log.With("id", ep.video.ID()).Warn("not updated")
+		}
+		w.videos = append(w.videos, ep.video)
+		if err := w.checkpoint(ep.name, func(cp *Checkpoint) {
+			cp.Finished = true
+			cp.Video = ep.video.ID()
+		}); err != nil {
+			return err
 		}
 	}
 
 	w.WorkImpl.Status = WorkFinish
+	w.completed()
 	return Wrap(w.Update(), "finished")
 }
 
@@ -477,12 +726,7 @@ func GetFileIndex(filename string) int {
 
 // GetNameIndex ...
 func GetNameIndex(name string) int {
-	last := LastSplit(FileAbsName(name), "@")
-	idx := ByteIndex(last[0])
-	if ByteIndex(last[0]) == -1 {
-		return 1
-	}
-	return idx + 1
+	return ParseEpisode(name).Episode
 }
 
 // FileAbsName ...
@@ -498,14 +742,7 @@ func FileAbsName(filename string) string {
 
 // FileName ...
 func FileName(filename string) string {
-	s := []rune(FileAbsName(filename))
-	last := len(s) - 1
-	if last > 0 && unicode.IsLetter(s[last]) {
-		if s[last-1] == '@' {
-			return string(s[:last-1])
-		}
-	}
-	return string(s)
+	return ParseEpisode(filename).Base
 }
 
 // IndexByte ...
@@ -1,6 +1,20 @@
 package conversion
 
-import "testing"
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/glvd/go-fftool"
+	"github.com/gocacher/cacher"
+	"github.com/gotrait/tool"
+)
 
 // TestGetFileIndex ...
 func TestGetFileIndex(t *testing.T) {
@@ -14,3 +28,345 @@ func TestGetFileIndex(t *testing.T) {
 		t.Failed()
 	}
 }
+
+// TestRegisterWorkType ...
+func TestRegisterWorkType(t *testing.T) {
+	e := RegisterWorkType("info", decodeInfo, nil, func(video IVideo) (string, error) {
+		return video.(*VideoInfo).ID, nil
+	})
+	if !errors.Is(e, ErrWorkTypeExist) {
+		t.Fatal(e)
+	}
+	if _, e := NewWork("info", VideoInfo{ID: "value"}); !errors.Is(e, ErrWrongCastType) {
+		t.Fatal(e)
+	}
+	bys, e := json.Marshal(newWork("removed", defaultWork(IDOption("removed-type")), nil))
+	if e != nil {
+		t.Fatal(e)
+	}
+	if e := cacher.Set("removed-type", bys); e != nil {
+		t.Fatal(e)
+	}
+	_, e = LoadWork("removed-type")
+	if !errors.Is(e, ErrWorkTypeNotFound) {
+		t.Fatal(e)
+	}
+	WorkRunProcessFunction["legacy"] = decodeInfo
+	defer delete(WorkRunProcessFunction, "legacy")
+	if _, e := getWorkType("legacy"); e != nil {
+		t.Fatal(e)
+	}
+}
+
+// TestInsertStage ...
+func TestInsertStage(t *testing.T) {
+	name := testWorkType(t)
+	watermark := NewStage("watermark", func(ctx context.Context, ep *Episode) error {
+		return nil
+	}, StageSlice)
+	if e := InsertStage(name, watermark, StageProbe); e == nil {
+		t.Fatal("dependency must be checked")
+	}
+	if e := InsertStage(name, watermark, StageSlice); e != nil {
+		t.Fatal(e)
+	}
+	stages, e := Pipeline(name)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if stageIndex(stages, "watermark") != stageIndex(stages, StageSlice)+1 {
+		t.Fatal("wrong stage order")
+	}
+	if e := ValidateSkip(name, "watermark", StageSource); e != nil {
+		t.Fatal(e)
+	}
+	if e := ValidateSkip(name, "unknown"); !errors.Is(e, ErrStageNotFound) {
+		t.Fatal(e)
+	}
+}
+
+// TestWorkResume ...
+func TestWorkResume(t *testing.T) {
+	var uploads, runs int
+	name := testWorkType(t, NewStage("upload", func(ctx context.Context, ep *Episode) error {
+		if s, b := ep.Finished("upload"); b {
+			ep.Video().SourceHash = s
+			return nil
+		}
+		uploads++
+		ep.Video().SourceHash = "QmResume"
+		return ep.Checkpoint("upload", "QmResume")
+	}), NewStage("flaky", func(ctx context.Context, ep *Episode) error {
+		if runs++; runs == 1 {
+			return errors.New("node timeout")
+		}
+		return nil
+	}))
+	work, e := NewWork(name, &VideoInfo{ID: tool.GenerateRandomString(8)}, VideoPathOption([]string{"resume.mp4"}))
+	if e != nil {
+		t.Fatal(e)
+	}
+	if e := work.Store(); e != nil {
+		t.Fatal(e)
+	}
+	if e := work.Run(context.Background()); e == nil {
+		t.Fatal("the first run must be failed")
+	}
+	//restarted from the stored work
+	work, e = LoadWork(work.ID())
+	if e != nil {
+		t.Fatal(e)
+	}
+	if e := work.Run(context.Background()); e != nil {
+		t.Fatal(e)
+	}
+	if uploads != 1 {
+		t.Errorf("finished stage was run %d times", uploads)
+	}
+	if videos := work.Work().Videos(); len(videos) != 1 || videos[0].SourceHash != "QmResume" {
+		t.Error("the saved hash must be reused")
+	}
+}
+
+// TestIsPermanent ...
+func TestIsPermanent(t *testing.T) {
+	if !IsPermanent(Wrap(ErrNotMedia, "run slice")) {
+		t.Error("not media should be permanent")
+	}
+	if !IsPermanent(Permanent(errors.New("bad input"))) {
+		t.Error("marked error should be permanent")
+	}
+	if IsPermanent(errors.New("node timeout")) {
+		t.Error("normal error should be retried")
+	}
+	if !IsPermanent(probeStage(context.Background(), &Episode{path: "missing.mp4"})) {
+		t.Error("missing file should be permanent")
+	}
+	task := NewTask()
+	task.RetryInterval = time.Second
+	task.MaxRetryInterval = 5 * time.Second
+	if d := task.backoff(3); d != 4*time.Second {
+		t.Errorf("backoff(3) = %v", d)
+	}
+	if d := task.backoff(10); d != 5*time.Second {
+		t.Errorf("backoff(10) = %v", d)
+	}
+}
+
+// TestWorkHistory ...
+func TestWorkHistory(t *testing.T) {
+	work, e := NewWork("info", &VideoInfo{ID: "history"})
+	if e != nil {
+		t.Fatal(e)
+	}
+	w := work.Work()
+	w.stageFinished("history", StageProbe)
+	w.failedAt("history", StageSource, errors.New("node timeout"))
+	w.failedAt("history", StageSlice, context.Canceled)
+	if e := w.Store(); e != nil {
+		t.Fatal(e)
+	}
+	load, e := LoadWork("history")
+	if e != nil {
+		t.Fatal(e)
+	}
+	impl := load.Work().WorkImpl
+	if impl.LastError != "node timeout" || impl.ErrorStage != StageSource || impl.ErrorEpisode != "history" {
+		t.Errorf("error was not stored: %+v", impl)
+	}
+	if impl.CreatedAt.IsZero() || len(load.Work().History()) != 1 {
+		t.Errorf("timing was not stored: %+v", impl)
+	}
+}
+
+// TestClearTemp ...
+func TestClearTemp(t *testing.T) {
+	dir, e := ioutil.TempDir("", "clear")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(dir)
+	w := newWork("info", defaultWork(ClearTempOption(false)), nil)
+	w.clearTemp(dir)
+	if _, e := os.Stat(dir); e != nil {
+		t.Fatal("temp must be kept")
+	}
+	w = newWork("info", defaultWork(TempRetentionOption(50*time.Millisecond)), nil)
+	w.clearTemp(dir)
+	if _, e := os.Stat(dir); e != nil {
+		t.Fatal("temp must be kept before retention")
+	}
+	time.Sleep(200 * time.Millisecond)
+	if _, e := os.Stat(dir); !os.IsNotExist(e) {
+		t.Fatal("temp must be removed after retention")
+	}
+	//the retention was expired before restart
+	if e := os.Mkdir(dir, 0755); e != nil {
+		t.Fatal(e)
+	}
+	_tempLock.Lock()
+	storeTempRetention([]tempRetention{{Path: dir, Expire: time.Now()}})
+	_tempLock.Unlock()
+	SweepTemp()
+	if _, e := os.Stat(dir); !os.IsNotExist(e) {
+		t.Fatal("expired temp must be removed by sweep")
+	}
+}
+
+// TestFragmentResume ...
+func TestFragmentResume(t *testing.T) {
+	dir, e := ioutil.TempDir("", "fragment")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(dir)
+	w := newWork("info", defaultWork(IDOption(tool.GenerateRandomString(8))), nil)
+	ep := &Episode{work: w, name: "1", path: "resume.mp4"}
+	if _, b := w.fragment(ep); b {
+		t.Fatal("nothing was sliced")
+	}
+	if e := w.checkpoint(ep.name, func(cp *Checkpoint) {
+		cp.Output = dir
+		cp.Temp = dir
+		cp.M3U8 = "media.m3u8"
+		cp.Renditions = []*Rendition{{Scale: fftool.Scale720P, M3U8: "media.m3u8"}}
+	}); e != nil {
+		t.Fatal(e)
+	}
+	f, b := w.fragment(ep)
+	if !b || f.Output() != dir || f.M3U8() != "media.m3u8" || len(f.Renditions()) != 1 {
+		t.Fatal("the kept slice must be resumed")
+	}
+	if e := w.Clear(); e != nil {
+		t.Fatal(e)
+	}
+	if _, e := os.Stat(dir); !os.IsNotExist(e) {
+		t.Fatal("the kept slice must be removed by clear")
+	}
+}
+
+// TestValidate ...
+func TestValidate(t *testing.T) {
+	dir, e := ioutil.TempDir("", "validate")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(dir)
+	poster := filepath.Join(dir, "poster.txt")
+	if e := ioutil.WriteFile(poster, []byte("poster"), 0755); e != nil {
+		t.Fatal(e)
+	}
+	work, e := NewWork("source", &VideoSource{Bangumi: "validate"},
+		VideoPathOption([]string{filepath.Join(dir, "none.mp4")}), PosterPathOption(poster), SkipOption("none"))
+	if e != nil {
+		t.Fatal(e)
+	}
+	task := NewTask()
+	task.Preflight = true
+	e = task.AddWorker(work, false)
+	var ve *ValidationError
+	if !errors.As(e, &ve) || !IsPermanent(e) {
+		t.Fatal(e)
+	}
+	var codes []string
+	for _, p := range ve.Problems {
+		codes = append(codes, p.Code)
+	}
+	want := []string{ProblemNotExist, ProblemNotPicture, ProblemUnknownStage}
+	if strings.Join(codes, ",") != strings.Join(want, ",") {
+		t.Errorf("problems = %v, want %v", codes, want)
+	}
+}
+
+// TestAcquire ...
+func TestAcquire(t *testing.T) {
+	release, e := acquire(context.Background(), ResourceCPU)
+	if e != nil {
+		t.Fatal(e)
+	}
+	release()
+	ctx := withResource(context.Background(), ResourceCPU, newResourcePool(1))
+	release, e = acquire(ctx, ResourceCPU)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if _, e := acquire(ctx, ResourceNetwork); e != nil {
+		t.Fatal("network must not be limited by cpu")
+	}
+	timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, e := acquire(timeout, ResourceCPU); !errors.Is(e, context.DeadlineExceeded) {
+		t.Fatal(e)
+	}
+	release()
+	if release, e = acquire(ctx, ResourceCPU); e != nil {
+		t.Fatal(e)
+	}
+	release()
+}
+
+// TestFindSliceHash ...
+func TestFindSliceHash(t *testing.T) {
+	checksum := tool.GenerateRandomString(16)
+	ep := &Episode{video: &Video{No: "dedup", Episode: "1"}}
+	source := ep.NewHash(HashTypeVideo, checksum, "QmSource")
+	slice := ep.NewHash(HashTypeSlice, checksum, "QmSlice")
+	slice.Sharpness, slice.M3U8 = "720P", fftool.DefaultM3U8Name
+	encrypted := ep.NewHash(HashTypeSlice, checksum, "QmEncrypted")
+	encrypted.Sharpness, encrypted.M3U8 = "720P", fftool.DefaultM3U8Name
+	encrypted.Encrypt, encrypted.Key = true, "K1"
+	for _, h := range []*Hash{source, slice, encrypted} {
+		if e := insertHash(h); e != nil {
+			t.Fatal(e)
+		}
+	}
+	renditions := []*Rendition{{Sharpness: "720P", M3U8: fftool.DefaultM3U8Name}}
+	//reused by the other episodes
+	for _, no := range []string{"dedup2", "dedup3"} {
+		reuse := &Episode{video: &Video{No: no, Episode: "1"}}
+		h, e := FindSourceHash(checksum)
+		if e != nil || h.Hash != "QmSource" {
+			t.Fatal("source must be reused", e)
+		}
+		hashes, e := FindSliceHash(checksum, false, "", renditions)
+		if e != nil || len(hashes) != 1 || hashes[0].Hash != "QmSlice" {
+			t.Fatalf("slice must be reused:%d,%v", len(hashes), e)
+		}
+		if e := reuse.reuseHash(hashes[0]); e != nil {
+			t.Fatal(e)
+		}
+	}
+	if hashes, e := FindSliceHash(checksum, true, "K2", renditions); e != nil || hashes != nil {
+		t.Fatal("slice encrypted by another key must not be reused")
+	}
+	if hashes, e := FindSliceHash(checksum, true, "K1", renditions); e != nil || len(hashes) != 1 {
+		t.Fatal("slice encrypted by the same key must be reused")
+	}
+}
+
+// TestWatchSlice ...
+func TestWatchSlice(t *testing.T) {
+	dir, e := ioutil.TempDir("", "watch")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"media-0.ts", "media-1.ts", "media.m3u8"} {
+		if e := ioutil.WriteFile(filepath.Join(dir, name), []byte(name), 0644); e != nil {
+			t.Fatal(e)
+		}
+	}
+	interval := ProgressInterval
+	ProgressInterval = 10 * time.Millisecond
+	defer func() {
+		ProgressInterval = interval
+	}()
+	p := newProgress("watch")
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	p.watchSlice(ctx, dir, 10, 40)
+	p.uploaded(FileSize(dir))
+	if v := p.Load(); v.Percent != 50 || v.Uploaded != FileSize(dir) {
+		t.Errorf("wrong progress:%+v", v)
+	}
+}
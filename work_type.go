@@ -0,0 +1,142 @@
+package conversion
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+)
+
+// WorkConstructFunc returns the options of the work made by the video
+type WorkConstructFunc func(video IVideo) []WorkOptions
+
+// WorkIDFunc returns the work id of the video,ErrWrongCastType if the video was not the type of work
+type WorkIDFunc func(video IVideo) (string, error)
+
+type workType struct {
+	name      string
+	decode    VideoProcessFunc
+	construct WorkConstructFunc
+	id        WorkIDFunc
+	pipeline  []Stage
+}
+
+// ErrWorkTypeExist ...
+var ErrWorkTypeExist = errors.New("work type was registered")
+
+// ErrWorkTypeNotFound ...
+var ErrWorkTypeNotFound = errors.New("work type not found")
+
+var _workTypes = map[string]*workType{}
+var _workTypeLock = &sync.RWMutex{}
+
+func init() {
+	mustRegisterWorkType("source", decodeSource, constructSource, func(video IVideo) (string, error) {
+		source, b := video.(*VideoSource)
+		if !b {
+			return "", Wrap(ErrWrongCastType, "source")
+		}
+		return source.Bangumi, nil
+	})
+	mustRegisterWorkType("info", decodeInfo, nil, func(video IVideo) (string, error) {
+		info, b := video.(*VideoInfo)
+		if !b {
+			return "", Wrap(ErrWrongCastType, "info")
+		}
+		return info.ID, nil
+	})
+}
+
+// RegisterWorkType ...
+func RegisterWorkType(name string, decode VideoProcessFunc, construct WorkConstructFunc, id WorkIDFunc) error {
+	if name == "" || decode == nil || id == nil {
+		return errors.New("work type name,decoder and id extractor must input")
+	}
+	_workTypeLock.Lock()
+	defer _workTypeLock.Unlock()
+	if _, b := _workTypes[name]; b {
+		return Wrap(ErrWorkTypeExist, name)
+	}
+	_workTypes[name] = &workType{
+		name:      name,
+		decode:    decode,
+		construct: construct,
+		id:        id,
+		pipeline:  DefaultPipeline(),
+	}
+	WorkRunProcessFunction[name] = decode
+	return nil
+}
+
+func unregisterWorkType(name string) {
+	_workTypeLock.Lock()
+	defer _workTypeLock.Unlock()
+	delete(_workTypes, name)
+	delete(WorkRunProcessFunction, name)
+}
+
+func mustRegisterWorkType(name string, decode VideoProcessFunc, construct WorkConstructFunc, id WorkIDFunc) {
+	if err := RegisterWorkType(name, decode, construct, id); err != nil {
+		panic(err)
+	}
+}
+
+func getWorkType(name string) (*workType, error) {
+	_workTypeLock.RLock()
+	defer _workTypeLock.RUnlock()
+	wt, b := _workTypes[name]
+	if b {
+		return wt, nil
+	}
+	//decoders set in WorkRunProcessFunction directly only run with the default pipeline
+	if fn, b := WorkRunProcessFunction[name]; b && fn != nil {
+		return &workType{
+			name:     name,
+			decode:   fn,
+			pipeline: DefaultPipeline(),
+		}, nil
+	}
+	return nil, Wrap(ErrWorkTypeNotFound, name)
+}
+
+// WorkTypes ...
+func WorkTypes() []string {
+	_workTypeLock.RLock()
+	defer _workTypeLock.RUnlock()
+	var names []string
+	for name := range _workTypes {
+		names = append(names, name)
+	}
+	return names
+}
+
+// NewWork ...
+func NewWork(name string, video IVideo, options ...WorkOptions) (IWork, error) {
+	wt, e := getWorkType(name)
+	if e != nil {
+		return nil, e
+	}
+	bys, e := json.Marshal(video)
+	if e != nil {
+		return nil, e
+	}
+	var opts []WorkOptions
+	if wt.id != nil {
+		id, e := wt.id(video)
+		if e != nil {
+			return nil, e
+		}
+		opts = append(opts, IDOption(id))
+	}
+	if wt.construct != nil {
+		opts = append(opts, wt.construct(video)...)
+	}
+	opts = append(opts, options...)
+	work := newWork(name, defaultWork(opts...), bys)
+	if work.ID() == "" {
+		return nil, ErrWorkID
+	}
+	if e := checkEpisodes(work.VideoPaths...); e != nil {
+		return nil, e
+	}
+	return work, nil
+}